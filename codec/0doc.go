@@ -2,12 +2,14 @@
 // Use of this source code is governed by a BSD-style license found in the LICENSE file.
 
 /*
-High Performance, Feature-Rich Idiomatic Go encoding library for msgpack and binc .
+High Performance, Feature-Rich Idiomatic Go encoding library for msgpack, binc, json and cbor .
 
 Supported Serialization formats are:
 
   - msgpack: [https://github.com/msgpack/msgpack]
   - binc: [http://github.com/ugorji/binc]
+  - json: [http://tools.ietf.org/html/rfc7159]
+  - cbor: [http://tools.ietf.org/html/rfc7049]
 
 To install:
 
@@ -33,6 +35,17 @@ Rich Feature Set includes:
   - Efficient zero-copying into temporary byte buffers  
     when encoding into or decoding from a byte slice.
   - Standard field renaming via tags
+  - Configurable struct tag keys (e.g. use the json tag if there is no codec tag)
+  - Configurable naming of untagged fields (e.g. snake_case), and case-insensitive matching of keys
+  - Unknown keys can be kept in a catch-all struct field, and are written back out on encode
+  - Raw type, to defer decoding a value (or write pre-encoded bytes verbatim)
+  - Registry of concrete types (by name or tag) per interface type, to encode and decode its values
+  - Supports TextMarshaler/TextUnmarshaler (as strings), including for map keys
+  - Optional fallback to json.Marshaler/json.Unmarshaler for third-party types
+  - Extensions can work on bytes, or on an intermediate value which is encoded natively
+  - Unregistered extensions are kept (as a RawExt) and written back unchanged
+  - Extensions can be registered for pointer, interface and unnamed types
+  - Native math/big support (Int, Float, Rat) for binc, and for msgpack via an extension tag
   - Encoding from any value  
     (struct, slice, map, primitives, pointers, interface{}, etc)
   - Decoding into pointer to any non-nil typed value  
@@ -43,13 +56,35 @@ Rich Feature Set includes:
     (decode into a pointer to a nil interface{} as opposed to a typed non-nil value).  
     Includes Options to configure what specific map or slice type to use 
     when decoding an encoded list or map into a nil interface{}
+  - Canonical encoding mode (sorted map keys, smallest number representation)  
+    so the same value always encodes to the same bytes
+  - Decode and Encode errors (DecodeError, EncodeError) report where they happened  
+    (the stream offset, and the path and type of the value)
+  - Options to limit container and string lengths, nesting depth and total bytes read,  
+    when decoding untrusted input
+  - Encoders and Decoders can be Reset to a new writer or reader and reused  
+    (e.g. from a sync.Pool)
+  - Low-level API to read a stream one value at a time (NextType, ReadArrayStart,  
+    ReadMapStart, More, Skip), without decoding whole containers
+  - Low-level API to write a stream one value at a time (WriteArrayStart,  
+    WriteMapStart, WriteEnd, WriteString, etc), without building whole containers
   - Provides a RPC Server and Client Codec for net/rpc communication protocol.
   - Msgpack Specific:
-      - Provides extension functions to handle spec-defined extensions (binary, timestamp)
+      - Native support for the spec-defined timestamp extension (type -1) for time.Time
+      - Provides extension functions to handle spec-defined extensions (binary),
+        and the legacy time.Time layout for compatibility with older streams
       - Options to resolve ambiguities in handling raw bytes (as string or []byte)  
         during schema-less decoding (decoding into a nil interface{})
       - RPC Server/Client Codec for msgpack-rpc protocol defined at: 
         http://wiki.msgpack.org/display/MSGPACK/RPC+specification
+  - Cbor Specific:
+      - Extension tags are written as CBOR semantic tags
+      - Decodes indefinite-length strings, arrays and maps
+      - time.Time is encoded as a date/time string (tag 0),
+        and decoded from a date/time string or epoch-based number (tags 0 and 1)
+  - Json Specific:
+      - Uses the same struct tags, extensions and options as the binary formats
+      - Raw bytes and extensions are encoded as base64 strings
 
 Extension Support
 
@@ -72,9 +107,10 @@ etc. However, with extension support, you can encode any of these
 however you like.
 
 We provide implementations of these functions where the spec has defined
-an inter-operable format. For msgpack, these are Binary and
-time.Time. Library users will have to explicitly configure these as seen
-in the usage below.
+an inter-operable format. For msgpack, this is Binary. Library users will
+have to explicitly configure these as seen in the usage below.
+(time.Time is supported natively using the msgpack timestamp extension.
+The legacy Time extension functions are still provided for older streams).
 
 Usage
 
@@ -90,12 +126,15 @@ Typical usage model:
     var (
       bh codec.BincHandle
       mh codec.MsgpackHandle
+      jh codec.JsonHandle
+      ch codec.CborHandle
     )
 
     mh.MapType = mapStrIntfTyp
     
-    // configure extensions for msgpack, to enable Binary and Time support for tags 0 and 1
+    // configure extensions for msgpack, to enable Binary support for tag 0
     mh.AddExt(sliceByteTyp, 0, mh.BinaryEncodeExt, mh.BinaryDecodeExt)
+    // (optional) use the legacy time.Time layout with tag 1, for older streams
     mh.AddExt(timeTyp, 1, mh.TimeEncodeExt, mh.TimeDecodeExt)
 
     // create and use decoder/encoder
@@ -103,7 +142,7 @@ Typical usage model:
       r io.Reader
       w io.Writer
       b []byte
-      h = &bh // or mh to use msgpack
+      h = &bh // or mh to use msgpack, jh to use json, ch to use cbor
     )
     
     dec = codec.NewDecoder(r, h)
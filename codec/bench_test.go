@@ -147,6 +147,41 @@ func fnBenchmarkDecode(b *testing.B, encName string, encfn benchEncFn, decfn ben
 	}
 }
 
+// fnBenchmarkEncodeParallel is like fnBenchmarkEncode, but runs on all Ps (see -cpu flag),
+// exercising the shared caches (e.g. typeInfo) which each new Encoder looks up.
+func fnBenchmarkEncodeParallel(b *testing.B, encName string, encfn benchEncFn) {
+	runtime.GC()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if _, err := encfn(benchTs); err != nil {
+				logT(b, "Error encoding benchTs: %s: %v", encName, err)
+				b.Fail()
+				return
+			}
+		}
+	})
+}
+
+func fnBenchmarkDecodeParallel(b *testing.B, encName string, encfn benchEncFn, decfn benchDecFn) {
+	buf, err := encfn(benchTs)
+	if err != nil {
+		logT(b, "Error encoding benchTs: %s: %v", encName, err)
+		b.FailNow()
+	}
+	runtime.GC()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if err := decfn(buf, new(TestStruc)); err != nil {
+				logT(b, "Error decoding into new TestStruc: %s: %v", encName, err)
+				b.Fail()
+				return
+			}
+		}
+	})
+}
+
 func verifyTsTree(b *testing.B, ts *TestStruc) {
 	var ts0, ts1m, ts2m, ts1s, ts2s *TestStruc
 	ts0 = ts
@@ -257,3 +292,31 @@ func Benchmark__Json_____Encode(b *testing.B) {
 func Benchmark__Json_____Decode(b *testing.B) {
 	fnBenchmarkDecode(b, "json", fnJsonEncodeFn, fnJsonDecodeFn)
 }
+
+func Benchmark__Msgpack__EncodeParallel(b *testing.B) {
+	fnBenchmarkEncodeParallel(b, "msgpack", fnMsgpackEncodeFn)
+}
+
+func Benchmark__Msgpack__DecodeParallel(b *testing.B) {
+	fnBenchmarkDecodeParallel(b, "msgpack", fnMsgpackEncodeFn, fnMsgpackDecodeFn)
+}
+
+func Benchmark__Binc_____EncodeParallel(b *testing.B) {
+	fnBenchmarkEncodeParallel(b, "binc", fnBincEncodeFn)
+}
+
+func Benchmark__Binc_____DecodeParallel(b *testing.B) {
+	fnBenchmarkDecodeParallel(b, "binc", fnBincEncodeFn, fnBincDecodeFn)
+}
+
+func Benchmark__TypeInfo_Parallel(b *testing.B) {
+	rt := reflect.TypeOf(benchTs)
+	rtid := reflect.ValueOf(rt).Pointer()
+	defTypeInfos.get(rtid, rt)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			defTypeInfos.get(rtid, rt)
+		}
+	})
+}
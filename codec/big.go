@@ -0,0 +1,150 @@
+// Copyright (c) 2012, 2013 Ugorji Nwoke. All rights reserved.
+// Use of this source code is governed by a BSD-style license found in the LICENSE file.
+
+package codec
+
+import (
+	"math"
+	"math/big"
+	"reflect"
+	"strings"
+)
+
+var (
+	bigIntTyp   = reflect.TypeOf(big.Int{})
+	bigFloatTyp = reflect.TypeOf(big.Float{})
+	bigRatTyp   = reflect.TypeOf(big.Rat{})
+
+	bigIntTypId   = reflect.ValueOf(bigIntTyp).Pointer()
+	bigFloatTypId = reflect.ValueOf(bigFloatTyp).Pointer()
+	bigRatTypId   = reflect.ValueOf(bigRatTyp).Pointer()
+)
+
+// isBigTypId returns true for big.Int, big.Float and big.Rat
+// (the base types of *big.Int, *big.Float and *big.Rat).
+func isBigTypId(rt uintptr) bool {
+	return rt == bigIntTypId || rt == bigFloatTypId || rt == bigRatTypId
+}
+
+// bigPtr returns a pointer to the big.Int, big.Float or big.Rat in rv,
+// copying it first if rv is not addressable.
+func bigPtr(rv reflect.Value) interface{} {
+	if !rv.CanAddr() {
+		rv2 := reflect.New(rv.Type()).Elem()
+		rv2.Set(rv)
+		rv = rv2
+	}
+	return rv.Addr().Interface()
+}
+
+// encodeBigText returns the kind ('i', 'f' or 'r') and the text of the
+// big.Int, big.Float or big.Rat in rv (e.g. "-12", "1.5e+100" or "1/3").
+func encodeBigText(rv reflect.Value) (kind byte, text string) {
+	switch x := bigPtr(rv).(type) {
+	case *big.Int:
+		return 'i', x.String()
+	case *big.Float:
+		return 'f', x.Text('g', -1)
+	case *big.Rat:
+		return 'r', x.String()
+	}
+	return
+}
+
+// decodeBigText sets the big.Int, big.Float or big.Rat in rv from its text.
+// A big.Float without a precision gets enough to hold all the digits (at least 64 bits).
+func decodeBigText(rv reflect.Value, text string) {
+	var ok bool
+	switch x := rv.Addr().Interface().(type) {
+	case *big.Int:
+		_, ok = x.SetString(text, 10)
+	case *big.Float:
+		if x.Prec() == 0 {
+			x.SetPrec(bigFloatPrec(text))
+		}
+		if strings.IndexByte(text, '/') >= 0 {
+			var r *big.Rat
+			if r, ok = new(big.Rat).SetString(text); ok {
+				x.SetRat(r)
+			}
+		} else {
+			_, ok = x.SetString(text)
+		}
+	case *big.Rat:
+		_, ok = x.SetString(text)
+	}
+	if !ok {
+		decErr("Invalid %v value: %q", rv.Type(), text)
+	}
+}
+
+// decodeBigNaked returns a *big.Int, *big.Float or *big.Rat (based on kind) from its text.
+func decodeBigNaked(kind byte, text string) interface{} {
+	var rv reflect.Value
+	switch kind {
+	case 'i':
+		rv = reflect.New(bigIntTyp)
+	case 'f':
+		rv = reflect.New(bigFloatTyp)
+	case 'r':
+		rv = reflect.New(bigRatTyp)
+	default:
+		decErr("Invalid math/big kind: %q", kind)
+	}
+	decodeBigText(rv.Elem(), text)
+	return rv.Interface()
+}
+
+// setBigNumber sets the big.Int, big.Float or big.Rat in rv from a number
+// found in the stream (an int64, uint64, float64 or *big.Int).
+func setBigNumber(rv reflect.Value, v interface{}) {
+	if f, ok := v.(float64); ok && (math.IsNaN(f) || math.IsInf(f, 0)) {
+		if _, ok = rv.Addr().Interface().(*big.Float); !ok || math.IsNaN(f) {
+			decErr("Cannot decode %v into %v", f, rv.Type())
+		}
+	}
+	switch x := rv.Addr().Interface().(type) {
+	case *big.Int:
+		switch n := v.(type) {
+		case int64:
+			x.SetInt64(n)
+		case uint64:
+			x.SetUint64(n)
+		case *big.Int:
+			x.Set(n)
+		default:
+			decErr("Cannot decode %v into %v", v, rv.Type())
+		}
+	case *big.Float:
+		switch n := v.(type) {
+		case int64:
+			x.SetInt64(n)
+		case uint64:
+			x.SetUint64(n)
+		case float64:
+			x.SetFloat64(n)
+		case *big.Int:
+			x.SetInt(n)
+		}
+	case *big.Rat:
+		switch n := v.(type) {
+		case int64:
+			x.SetInt64(n)
+		case uint64:
+			x.SetUint64(n)
+		case float64:
+			x.SetFloat64(n)
+		case *big.Int:
+			x.SetInt(n)
+		}
+	}
+}
+
+// bigFloatPrec returns the precision (in bits) used to decode a big.Float from text.
+func bigFloatPrec(text string) uint {
+	// a decimal digit takes less than 4 bits
+	if p := uint(len(text)) * 4; p > 64 {
+		return p
+	}
+	return 64
+}
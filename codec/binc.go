@@ -5,7 +5,9 @@ package codec
 
 import (
 	"math"
+	"math/big"
 	"reflect"
+	"strings"
 	"sync/atomic"
 	"time"
 	//"fmt"
@@ -17,14 +19,18 @@ import (
 //defined at https://github.com/ugorji/binc .
 //
 //BincHandle currently supports all Binc features with the following EXCEPTIONS:
-//  - only integers up to 64 bits of precision are supported.
-//    big integers are unsupported.
+//  - big integers are only supported via big.Int (and *big.Int).
+//    An integer which does not fit in 64 bits is written with 0x8 set in the vs bits,
+//    followed by its length and its big-endian bytes (two's complement for an int).
 //  - Only IEEE 754 binary32 and binary64 floats are supported (ie Go float32 and float64 types).
-//    extended precision and decimal IEEE 754 floats are unsupported.
+//    extended precision IEEE 754 floats are unsupported.
+//    Decimals are written from big.Float and big.Rat (and pointers to them) as text
+//    (e.g. "1.5e+100" or "1/3"), and decoded into them.
 //  - Only UTF-8 strings supported.
 //    Unicode_Other Binc types (UTF16, UTF32) are currently unsupported.
 //Note that these EXCEPTIONS are temporary and full support is possible and may happen soon.
 type BincHandle struct {
+	BasicHandle
 	extHandle
 	EncodeOptions
 	DecodeOptions
@@ -75,13 +81,16 @@ const (
 )
 
 type bincEncDriver struct {
+	encNoSeparator
 	w encWriter
+	h *BincHandle
 	m map[string]uint16 // symbols
 	s uint32            // symbols sequencer
 	b [8]byte
 }
 
 type bincDecDriver struct {
+	decNoSeparator
 	r      decReader
 	h      *BincHandle
 	bdRead bool
@@ -91,22 +100,39 @@ type bincDecDriver struct {
 	vs     byte
 	b      [8]byte
 	m      map[uint32]string // symbols (use uint32 as key, as map optimizes for it)
+	raw    bool              // reading a Raw value
+	rawPos int64             // position of the first byte of the Raw value
+	rawSym []bincRawSymbol   // symbols found in the Raw value
 }
 
-func (_ *BincHandle) newEncDriver(w encWriter) encDriver {
-	return &bincEncDriver{w: w}
+// bincRawSymbol is a symbol found in a Raw value, at raw[start:end].
+type bincRawSymbol struct {
+	start, end int
+	s          string
+}
+
+func (h *BincHandle) newEncDriver(w encWriter) encDriver {
+	return &bincEncDriver{w: w, h: h}
 }
 
 func (h *BincHandle) newDecDriver(r decReader) decDriver {
 	return &bincDecDriver{r: r, h: h}
 }
 
+func (_ *BincHandle) valueSeparator() bool {
+	return false
+}
+
+func (_ *BincHandle) nativeJson() bool {
+	return false
+}
+
 func (_ *BincHandle) writeExt() bool {
 	return true
 }
 
 func (e *bincEncDriver) isBuiltinType(rt uintptr) bool {
-	return rt == timeTypId
+	return rt == timeTypId || isBigTypId(rt)
 }
 
 func (e *bincEncDriver) encodeBuiltinType(rt uintptr, rv reflect.Value) {
@@ -115,7 +141,36 @@ func (e *bincEncDriver) encodeBuiltinType(rt uintptr, rv reflect.Value) {
 		bs := encodeTime(rv.Interface().(time.Time))
 		e.w.writen1(bincVdTimestamp<<4 | uint8(len(bs)))
 		e.w.writeb(bs)
+	case bigIntTypId:
+		e.encodeBigInt(bigPtr(rv).(*big.Int))
+	case bigFloatTypId, bigRatTypId:
+		_, s := encodeBigText(rv)
+		e.encLen(bincVdDecimal<<4, uint64(len(s)))
+		e.w.writestr(s)
+	}
+}
+
+// encodeBigInt writes x as a regular integer if it fits in 64 bits.
+func (e *bincEncDriver) encodeBigInt(x *big.Int) {
+	switch {
+	case x.IsInt64():
+		e.encodeInt(x.Int64())
+		return
+	case x.IsUint64():
+		e.encodeUint(x.Uint64())
+		return
+	}
+	bd := bincVdUint << 4
+	bs := x.Bytes()
+	if x.Sign() < 0 {
+		// two's complement, with a leading byte for the sign
+		bd = bincVdInt << 4
+		t := new(big.Int).Lsh(big.NewInt(1), uint(8*(len(bs)+1)))
+		bs = t.Add(t, x).Bytes()
+		bs = bs[pruneSignExt(bs):]
 	}
+	e.encLenNumber(bd|0x8, uint64(len(bs)))
+	e.w.writeb(bs)
 }
 
 func (e *bincEncDriver) encodeNil() {
@@ -131,6 +186,11 @@ func (e *bincEncDriver) encodeBool(b bool) {
 }
 
 func (e *bincEncDriver) encodeFloat32(f float32) {
+	if e.h.Canonical {
+		// write the same bytes as the equivalent float64
+		e.encodeFloat64(float64(f))
+		return
+	}
 	if f == 0 {
 		e.w.writen1(bincVdSpecial<<4 | bincSpZeroFloat)
 		return
@@ -187,6 +247,8 @@ func (e *bincEncDriver) encodeInt(v int64) {
 		e.w.writen1(bincVdSpecial<<4 | bincSpNegOne)
 	case v >= 1 && v <= 16:
 		e.w.writen1(bincVdSmallInt<<4 | byte(v-1))
+	case v > 0 && e.h.Canonical:
+		e.encodeUint(uint64(v))
 	case v >= math.MinInt8 && v <= math.MaxInt8:
 		e.w.writen2(bd|0x0, byte(v))
 	case v >= math.MinInt16 && v <= math.MaxInt16:
@@ -202,6 +264,10 @@ func (e *bincEncDriver) encodeInt(v int64) {
 func (e *bincEncDriver) encodeUint(v uint64) {
 	const bd byte = bincVdUint << 4
 	switch {
+	case v == 0 && e.h.Canonical:
+		e.w.writen1(bincVdSpecial<<4 | bincSpZero)
+	case v <= 16 && e.h.Canonical:
+		e.w.writen1(bincVdSmallInt<<4 | byte(v-1))
 	case v <= math.MaxUint8:
 		e.w.writen2(bd|0x0, byte(v))
 	case v <= math.MaxUint16:
@@ -236,8 +302,6 @@ func (e *bincEncDriver) encodeString(c charEncoding, v string) {
 }
 
 func (e *bincEncDriver) encodeSymbol(v string) {
-	//if true { e.encodeString(c_UTF8, v); return; }
-
 	//symbols only offer benefit when string length > 1.
 	//This is because strings with length 1 take only 2 bytes to store
 	//(bd with embedded length, and single byte for string val).
@@ -340,8 +404,19 @@ func (e *bincEncDriver) encLenNumber(bd byte, v uint64) {
 	}
 }
 
+func (e *bincEncDriver) reset(w encWriter) {
+	e.w = w
+	e.m, e.s = nil, 0
+}
+
 //------------------------------------
 
+func (d *bincDecDriver) reset(r decReader) {
+	d.r = r
+	d.bdRead = false
+	d.m = nil
+}
+
 func (d *bincDecDriver) initReadNext() {
 	if d.bdRead {
 		return
@@ -375,7 +450,7 @@ func (d *bincDecDriver) currentEncodedType() decodeEncodedType {
 			d.bdType = detUint
 		case bincVdInt:
 			d.bdType = detInt
-		case bincVdFloat:
+		case bincVdFloat, bincVdDecimal:
 			d.bdType = detFloat
 		case bincVdSymbol, bincVdString:
 			d.bdType = detString
@@ -405,7 +480,7 @@ func (d *bincDecDriver) tryDecodeAsNil() bool {
 }
 
 func (d *bincDecDriver) isBuiltinType(rt uintptr) bool {
-	return rt == timeTypId
+	return rt == timeTypId || isBigTypId(rt)
 }
 
 func (d *bincDecDriver) decodeBuiltinType(rt uintptr, rv reflect.Value) {
@@ -420,7 +495,42 @@ func (d *bincDecDriver) decodeBuiltinType(rt uintptr, rv reflect.Value) {
 		}
 		rv.Set(reflect.ValueOf(tt))
 		d.bdRead = false
+	case bigIntTypId, bigFloatTypId, bigRatTypId:
+		switch {
+		case d.vd == bincVdDecimal:
+			decodeBigText(rv, d.decDecimal())
+		case (d.vd == bincVdUint || d.vd == bincVdInt) && d.vs&0x8 != 0:
+			setBigNumber(rv, d.decBigInt())
+		case d.vd == bincVdFloat, d.vd == bincVdSpecial && d.vs != bincSpZero && d.vs != bincSpNegOne:
+			setBigNumber(rv, d.decodeFloat(false))
+		case d.vd == bincVdUint:
+			setBigNumber(rv, d.decodeUint(0))
+		default:
+			setBigNumber(rv, d.decodeInt(0))
+		}
+	}
+}
+
+// decBigInt reads an integer which does not fit in 64 bits (0x8 set in the vs bits).
+func (d *bincDecDriver) decBigInt() *big.Int {
+	d.vs &= 0x3
+	bs := d.r.readn(d.decBytesLen())
+	x := new(big.Int).SetBytes(bs)
+	if d.vd == bincVdInt && len(bs) > 0 && bs[0]&0x80 != 0 {
+		x.Sub(x, new(big.Int).Lsh(big.NewInt(1), uint(8*len(bs))))
+	}
+	d.bdRead = false
+	return x
+}
+
+// decDecimal reads the text of a decimal.
+func (d *bincDecDriver) decDecimal() (s string) {
+	if d.vd != bincVdDecimal {
+		decErr("Invalid d.vd. Expecting 0x%x. Received: 0x%x", bincVdDecimal, d.vd)
 	}
+	s = string(d.r.readn(d.decBytesLen()))
+	d.bdRead = false
+	return
 }
 
 func (d *bincDecDriver) decFloatPre(vs, defaultLen byte) {
@@ -600,6 +710,24 @@ func (d *bincDecDriver) decodeFloat(chkOverflow32 bool) (f float64) {
 		}		
 	case bincVdFloat:
 		f = d.decFloat()
+	case bincVdDecimal:
+		// the text of a big.Rat (e.g. "1/3") or of a big.Float (e.g. "1.5e+100" or "+Inf")
+		s := d.decDecimal()
+		var ok bool
+		if strings.IndexByte(s, '/') >= 0 {
+			var r *big.Rat
+			if r, ok = new(big.Rat).SetString(s); ok {
+				f, _ = r.Float64()
+			}
+		} else {
+			var bf *big.Float
+			if bf, ok = new(big.Float).SetPrec(bigFloatPrec(s)).SetString(s); ok {
+				f, _ = bf.Float64()
+			}
+		}
+		if !ok {
+			decErr("Invalid decimal value: %q", s)
+		}
 	case bincVdUint:
 		f = float64(d.decUint())
 	default:
@@ -639,6 +767,7 @@ func (d *bincDecDriver) readMapLen() (length int) {
 		decErr("Invalid d.vd for map. Expecting 0x%x. Got: 0x%x", bincVdMap, d.vd)
 	}
 	length = d.decLen()
+	d.h.checkContainerLen(length)
 	d.bdRead = false
 	return
 }
@@ -648,21 +777,33 @@ func (d *bincDecDriver) readArrayLen() (length int) {
 		decErr("Invalid d.vd for array. Expecting 0x%x. Got: 0x%x", bincVdArray, d.vd)
 	}
 	length = d.decLen()
+	d.h.checkContainerLen(length)
 	d.bdRead = false
 	return
 }
 
 func (d *bincDecDriver) decLen() int {
 	if d.vs <= 3 {
-		return int(d.decUint())
+		v := d.decUint()
+		if int(v) < 0 || uint64(int(v)) != v {
+			decErr("length overflows int: %v", v)
+		}
+		return int(v)
 	}
 	return int(d.vs - 4)
 }
 
+// decBytesLen reads the length of a string, byte array or extension.
+func (d *bincDecDriver) decBytesLen() (l int) {
+	l = d.decLen()
+	d.h.checkBytesLen(l)
+	return
+}
+
 func (d *bincDecDriver) decodeString() (s string) {
 	switch d.vd {
 	case bincVdString, bincVdByteArray:
-		if length := d.decLen(); length > 0 {
+		if length := d.decBytesLen(); length > 0 {
 			s = string(d.r.readn(length))
 		}
 	case bincVdSymbol:
@@ -696,6 +837,10 @@ func (d *bincDecDriver) decodeString() (s string) {
 			case 3:
 				slen = int(d.r.readUint64())
 			}
+			if slen < 0 {
+				decErr("length overflows int: %v", slen)
+			}
+			d.h.checkBytesLen(slen)
 			s = string(d.r.readn(slen))
 			d.m[symbol] = s
 		}
@@ -711,7 +856,7 @@ func (d *bincDecDriver) decodeBytes(bs []byte) (bsOut []byte, changed bool) {
 	var clen int
 	switch d.vd {
 	case bincVdString, bincVdByteArray:
-		clen = d.decLen()
+		clen = d.decBytesLen()
 	default:
 		decErr("Invalid d.vd for bytes. Expecting string:0x%x or bytearray:0x%x. Got: 0x%x",
 			bincVdString, bincVdByteArray, d.vd)
@@ -733,11 +878,12 @@ func (d *bincDecDriver) decodeBytes(bs []byte) (bsOut []byte, changed bool) {
 	return
 }
 
-func (d *bincDecDriver) decodeExt(tag byte) (xbs []byte) {
+func (d *bincDecDriver) decodeExt(verifyTag bool, tag byte) (xtag byte, xbs []byte) {
+	xtag = tag
 	switch d.vd {
 	case bincVdCustomExt:
-		l := d.decLen()
-		if xtag := d.r.readn1(); xtag != tag {
+		l := d.decBytesLen()
+		if xtag = d.r.readn1(); verifyTag && xtag != tag {
 			decErr("Wrong extension tag. Got %b. Expecting: %v", xtag, tag)
 		}
 		xbs = d.r.readn(l)
@@ -750,6 +896,85 @@ func (d *bincDecDriver) decodeExt(tag byte) (xbs []byte) {
 	return
 }
 
+func (d *bincDecDriver) decodeRaw() []byte {
+	d.initReadNext()
+	bd := d.bd
+	d.rawPos = d.r.numread() - 1
+	d.r.track()
+	d.raw = true
+	defer func() {
+		d.raw = false
+		d.rawSym = d.rawSym[:0]
+	}()
+	d.swallow()
+	bs := decRawBytes(bd, d.r.stopTrack())
+	if len(d.rawSym) > 0 {
+		bs = d.resolveRawSymbols(bs)
+	}
+	return bs
+}
+
+// resolveRawSymbols returns the Raw value bs, with its symbols written as strings.
+// Symbols are only meaningful within the stream they were read from,
+// so a Raw value must not refer to them.
+func (d *bincDecDriver) resolveRawSymbols(bs []byte) (out []byte) {
+	w := &bytesEncWriter{b: make([]byte, 0, len(bs)), out: &out}
+	e := bincEncDriver{w: w, h: d.h}
+	var j int
+	for _, x := range d.rawSym {
+		w.writeb(bs[j:x.start])
+		e.encodeString(c_UTF8, x.s)
+		j = x.end
+	}
+	w.writeb(bs[j:])
+	w.atEndOfEncode()
+	return
+}
+
+func (d *bincDecDriver) swallow() {
+	for left := 1; left > 0; left-- {
+		d.swallow1(&left)
+	}
+}
+
+func (d *bincDecDriver) swallow1(left *int) {
+	d.initReadNext()
+	var n int
+	switch d.vd {
+	case bincVdSpecial, bincVdSmallInt:
+	case bincVdUint, bincVdInt:
+		if d.vs&0x8 != 0 {
+			d.vs &= 0x3
+			n = d.decBytesLen()
+		} else {
+			n = int(d.vs) + 1
+		}
+	case bincVdFloat:
+		d.decFloat()
+	case bincVdString, bincVdByteArray, bincVdDecimal:
+		n = d.decBytesLen()
+	case bincVdSymbol:
+		// symbols must still be recorded, as later values may refer to them.
+		start := d.r.numread() - 1
+		s := d.decodeString()
+		if d.raw {
+			d.rawSym = append(d.rawSym, bincRawSymbol{int(start - d.rawPos), int(d.r.numread() - d.rawPos), s})
+		}
+	case bincVdTimestamp:
+		n = int(d.vs)
+	case bincVdCustomExt:
+		n = d.decBytesLen() + 1 // include the tag
+	case bincVdArray:
+		*left += d.readArrayLen()
+	case bincVdMap:
+		*left += 2 * d.readMapLen()
+	default:
+		decErr("swallow: Unrecognized d.vd: 0x%x", d.vd)
+	}
+	d.bdRead = false
+	d.r.skip(n)
+}
+
 func (d *bincDecDriver) decodeNaked() (rv reflect.Value, ctx decodeNakedContext) {
 	d.initReadNext()
 	var v interface{}
@@ -781,12 +1006,22 @@ func (d *bincDecDriver) decodeNaked() (rv reflect.Value, ctx decodeNakedContext)
 		}
 	case bincVdSmallInt:
 		v = int8(d.vs) + 1
-	case bincVdUint:
-		v = d.decUint()
-	case bincVdInt:
-		v = d.decInt()
+	case bincVdUint, bincVdInt:
+		if d.vs&0x8 != 0 {
+			v = d.decBigInt()
+		} else if d.vd == bincVdUint {
+			v = d.decUint()
+		} else {
+			v = d.decInt()
+		}
 	case bincVdFloat:
 		v = d.decFloat()
+	case bincVdDecimal:
+		if s := d.decDecimal(); strings.IndexByte(s, '/') >= 0 {
+			v = decodeBigNaked('r', s)
+		} else {
+			v = decodeBigNaked('f', s)
+		}
 	case bincVdSymbol:
 		v = d.decodeString()
 	case bincVdString:
@@ -801,14 +1036,16 @@ func (d *bincDecDriver) decodeNaked() (rv reflect.Value, ctx decodeNakedContext)
 		v = tt
 	case bincVdCustomExt:
 		//ctx = dncExt
-		l := d.decLen()
+		l := d.decBytesLen()
 		xtag := d.r.readn1()
-		var bfn func(reflect.Value, []byte) error
-		rv, bfn = d.h.getDecodeExtForTag(xtag)
-		if bfn == nil {
-			decErr("decodeNaked: Unable to find type mapped to extension tag: %v", xtag)
+		var xf *extTypeTagFn
+		rv, xf = d.h.getDecodeExtForTag(xtag)
+		if xf == nil {
+			// unregistered tag: keep it as is
+			v = RawExt{xtag, append(make([]byte, 0, l), d.r.readn(l)...)}
+			break
 		}
-		if fnerr := bfn(rv, d.r.readn(l)); fnerr != nil {
+		if fnerr := xf.decode(d.h, rv, d.r.readn(l)); fnerr != nil {
 			panic(fnerr)
 		}
 	case bincVdArray:
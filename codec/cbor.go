@@ -0,0 +1,759 @@
+// Copyright (c) 2012, 2013 Ugorji Nwoke. All rights reserved.
+// Use of this source code is governed by a BSD-style license found in the LICENSE file.
+
+package codec
+
+import (
+	"math"
+	"reflect"
+	"time"
+)
+
+const (
+	cborMajorUint byte = iota
+	cborMajorNegInt
+	cborMajorBytes
+	cborMajorText
+	cborMajorArray
+	cborMajorMap
+	cborMajorTag
+	cborMajorOther
+)
+
+const (
+	cborBdFalse byte = 0xf4 + iota
+	cborBdTrue
+	cborBdNil
+	cborBdUndefined
+	cborBdExt
+	cborBdFloat16
+	cborBdFloat32
+	cborBdFloat64
+)
+
+const (
+	cborBdIndefiniteBytes  byte = 0x5f
+	cborBdIndefiniteString      = 0x7f
+	cborBdIndefiniteArray       = 0x9f
+	cborBdIndefiniteMap         = 0xbf
+	cborBdBreak                 = 0xff
+)
+
+const (
+	cborTagDateTimeString uint64 = 0
+	cborTagEpochTime             = 1
+)
+
+//CborHandle is a Handle for the CBOR encoding format,
+//defined at http://tools.ietf.org/html/rfc7049 .
+//
+//CborHandle supports:
+//  - all major types.
+//  - indefinite-length byte strings, text strings, arrays and maps when decoding.
+//    Encoding always writes definite lengths.
+//  - semantic tags: extensions registered via AddExt are written as the
+//    extension tag, followed by the extension bytes as a byte string.
+//    Extension tags share the IANA CBOR tag space (e.g. 2 and 3 are bignums),
+//    so pick tags that are not already assigned there.
+//  - time.Time is encoded as a date/time string (tag 0), and can be decoded
+//    from a date/time string (tag 0) or an epoch-based number (tag 1).
+//
+//When decoding into a nil interface{}, positive integers are decoded as uint64
+//and negative integers as int64. Registered extensions are looked up first, so an
+//extension registered with tag 0 or 1 takes precedence over time.Time. A value
+//with an unregistered tag (up to 255) whose content is a byte string (e.g. a bignum)
+//is decoded as a RawExt, and so is written back unchanged. Any other unregistered
+//tag is dropped, and the tagged value is decoded as if the tag was not there.
+type CborHandle struct {
+	BasicHandle
+	extHandle
+	EncodeOptions
+	DecodeOptions
+}
+
+type cborEncDriver struct {
+	encNoSeparator
+	w encWriter
+	h *CborHandle
+}
+
+type cborDecDriver struct {
+	decNoSeparator
+	r      decReader
+	h      *CborHandle
+	bdRead bool
+	bdType decodeEncodedType
+	bd     byte
+}
+
+func (h *CborHandle) newEncDriver(w encWriter) encDriver {
+	return &cborEncDriver{w: w, h: h}
+}
+
+func (h *CborHandle) newDecDriver(r decReader) decDriver {
+	return &cborDecDriver{r: r, h: h}
+}
+
+func (_ *CborHandle) valueSeparator() bool {
+	return false
+}
+
+func (_ *CborHandle) nativeJson() bool {
+	return false
+}
+
+func (_ *CborHandle) writeExt() bool {
+	return true
+}
+
+// ---------------------------------------------
+
+func (e *cborEncDriver) isBuiltinType(rt uintptr) bool {
+	return rt == timeTypId
+}
+
+func (e *cborEncDriver) encodeBuiltinType(rt uintptr, rv reflect.Value) {
+	switch rt {
+	case timeTypId:
+		e.encUint(cborTagDateTimeString, cborMajorTag)
+		e.encodeString(c_UTF8, rv.Interface().(time.Time).Format(time.RFC3339Nano))
+	}
+}
+
+func (e *cborEncDriver) encodeNil() {
+	e.w.writen1(cborBdNil)
+}
+
+func (e *cborEncDriver) encodeBool(b bool) {
+	if b {
+		e.w.writen1(cborBdTrue)
+	} else {
+		e.w.writen1(cborBdFalse)
+	}
+}
+
+func (e *cborEncDriver) encodeFloat32(f float32) {
+	e.w.writen1(cborBdFloat32)
+	e.w.writeUint32(math.Float32bits(f))
+}
+
+func (e *cborEncDriver) encodeFloat64(f float64) {
+	e.w.writen1(cborBdFloat64)
+	e.w.writeUint64(math.Float64bits(f))
+}
+
+// encUint writes the initial byte for the major type, with v as its argument
+// (e.g. the value of an unsigned integer, or the length of a string or container).
+func (e *cborEncDriver) encUint(v uint64, major byte) {
+	bd := major << 5
+	switch {
+	case v < 0x18:
+		e.w.writen1(bd | byte(v))
+	case v <= math.MaxUint8:
+		e.w.writen2(bd|0x18, byte(v))
+	case v <= math.MaxUint16:
+		e.w.writen1(bd | 0x19)
+		e.w.writeUint16(uint16(v))
+	case v <= math.MaxUint32:
+		e.w.writen1(bd | 0x1a)
+		e.w.writeUint32(uint32(v))
+	default:
+		e.w.writen1(bd | 0x1b)
+		e.w.writeUint64(v)
+	}
+}
+
+func (e *cborEncDriver) encodeInt(v int64) {
+	if v < 0 {
+		e.encUint(uint64(-1-v), cborMajorNegInt)
+	} else {
+		e.encUint(uint64(v), cborMajorUint)
+	}
+}
+
+func (e *cborEncDriver) encodeUint(v uint64) {
+	e.encUint(v, cborMajorUint)
+}
+
+func (e *cborEncDriver) encodeExtPreamble(xtag byte, length int) {
+	e.encUint(uint64(xtag), cborMajorTag)
+	e.encUint(uint64(length), cborMajorBytes)
+}
+
+func (e *cborEncDriver) encodeArrayPreamble(length int) {
+	e.encUint(uint64(length), cborMajorArray)
+}
+
+func (e *cborEncDriver) encodeMapPreamble(length int) {
+	e.encUint(uint64(length), cborMajorMap)
+}
+
+func (e *cborEncDriver) encodeString(c charEncoding, v string) {
+	if c == c_RAW {
+		e.encUint(uint64(len(v)), cborMajorBytes)
+	} else {
+		e.encUint(uint64(len(v)), cborMajorText)
+	}
+	if len(v) > 0 {
+		e.w.writestr(v)
+	}
+}
+
+func (e *cborEncDriver) encodeSymbol(v string) {
+	e.encodeString(c_UTF8, v)
+}
+
+func (e *cborEncDriver) encodeStringBytes(c charEncoding, v []byte) {
+	if c == c_RAW {
+		e.encUint(uint64(len(v)), cborMajorBytes)
+	} else {
+		e.encUint(uint64(len(v)), cborMajorText)
+	}
+	if len(v) > 0 {
+		e.w.writeb(v)
+	}
+}
+
+func (e *cborEncDriver) reset(w encWriter) {
+	e.w = w
+}
+
+// ---------------------------------------------
+
+func (d *cborDecDriver) reset(r decReader) {
+	d.r = r
+	d.bdRead = false
+}
+
+// Every top-level decode funcs (i.e. decodeValue, decode) must call this first.
+func (d *cborDecDriver) initReadNext() {
+	if d.bdRead {
+		return
+	}
+	d.bd = d.r.readn1()
+	d.bdRead = true
+	d.bdType = detUnset
+}
+
+func (d *cborDecDriver) currentEncodedType() decodeEncodedType {
+	if d.bdType == detUnset {
+		switch d.bd {
+		case cborBdNil, cborBdUndefined:
+			d.bdType = detNil
+		case cborBdFalse, cborBdTrue:
+			d.bdType = detBool
+		case cborBdFloat16, cborBdFloat32, cborBdFloat64:
+			d.bdType = detFloat
+		default:
+			switch d.bd >> 5 {
+			case cborMajorUint:
+				d.bdType = detUint
+			case cborMajorNegInt:
+				d.bdType = detInt
+			case cborMajorBytes:
+				d.bdType = detBytes
+			case cborMajorText:
+				d.bdType = detString
+			case cborMajorArray:
+				d.bdType = detArray
+			case cborMajorMap:
+				d.bdType = detMap
+			case cborMajorTag:
+				d.bdType = detExt
+			default:
+				decErr("currentEncodedType: Undeciphered descriptor: %s: hex: %x, dec: %d", msgBadDesc, d.bd, d.bd)
+			}
+		}
+	}
+	return d.bdType
+}
+
+func (d *cborDecDriver) tryDecodeAsNil() bool {
+	if d.bd == cborBdNil || d.bd == cborBdUndefined {
+		d.bdRead = false
+		return true
+	}
+	return false
+}
+
+func (d *cborDecDriver) checkBreak() bool {
+	d.initReadNext()
+	if d.bd == cborBdBreak {
+		d.bdRead = false
+		return true
+	}
+	return false
+}
+
+// decUint reads the argument of the current initial byte d.bd.
+func (d *cborDecDriver) decUint() (ui uint64) {
+	switch v := d.bd & 0x1f; {
+	case v < 0x18:
+		ui = uint64(v)
+	case v == 0x18:
+		ui = uint64(d.r.readn1())
+	case v == 0x19:
+		ui = uint64(d.r.readUint16())
+	case v == 0x1a:
+		ui = uint64(d.r.readUint32())
+	case v == 0x1b:
+		ui = d.r.readUint64()
+	default:
+		decErr("decUint: Invalid descriptor: %s: hex: %x, dec: %d", msgBadDesc, d.bd, d.bd)
+	}
+	return
+}
+
+func (d *cborDecDriver) decLen() int {
+	v := d.decUint()
+	if int(v) < 0 || uint64(int(v)) != v {
+		decErr("length overflows int: %v", v)
+	}
+	return int(v)
+}
+
+func (d *cborDecDriver) decodeInt(bitsize uint8) (i int64) {
+	switch major := d.bd >> 5; major {
+	case cborMajorUint, cborMajorNegInt:
+		ui := d.decUint()
+		if ui > math.MaxInt64 {
+			decErr("Overflow int value: %v", ui)
+		}
+		if i = int64(ui); major == cborMajorNegInt {
+			i = -1 - i
+		}
+	default:
+		decErr("Invalid descriptor for integer: %s: %x", msgBadDesc, d.bd)
+	}
+	// check overflow (logic adapted from std pkg reflect/value.go OverflowUint()
+	if bitsize > 0 {
+		if trunc := (i << (64 - bitsize)) >> (64 - bitsize); i != trunc {
+			decErr("Overflow int value: %v", i)
+		}
+	}
+	d.bdRead = false
+	return
+}
+
+func (d *cborDecDriver) decodeUint(bitsize uint8) (ui uint64) {
+	switch d.bd >> 5 {
+	case cborMajorUint:
+		ui = d.decUint()
+	case cborMajorNegInt:
+		decErr("Assigning negative signed value: -1-%v, to unsigned type", d.decUint())
+	default:
+		decErr("Invalid descriptor for unsigned integer: %s: %x", msgBadDesc, d.bd)
+	}
+	// check overflow (logic adapted from std pkg reflect/value.go OverflowUint()
+	if bitsize > 0 {
+		if trunc := (ui << (64 - bitsize)) >> (64 - bitsize); ui != trunc {
+			decErr("Overflow uint value: %v", ui)
+		}
+	}
+	d.bdRead = false
+	return
+}
+
+// float can be decoded from half, single or double precision floats, or integers.
+func (d *cborDecDriver) decodeFloat(chkOverflow32 bool) (f float64) {
+	switch bd := d.bd; {
+	case bd == cborBdFloat16:
+		f = float64(math.Float32frombits(halfFloatToFloatBits(d.r.readUint16())))
+	case bd == cborBdFloat32:
+		f = float64(math.Float32frombits(d.r.readUint32()))
+	case bd == cborBdFloat64:
+		f = math.Float64frombits(d.r.readUint64())
+	case bd>>5 == cborMajorUint:
+		f = float64(d.decUint())
+	case bd>>5 == cborMajorNegInt:
+		f = float64(d.decodeInt(0))
+	default:
+		decErr("Invalid descriptor for float: %s: %x", msgBadDesc, d.bd)
+	}
+	// check overflow (logic adapted from std pkg reflect/value.go OverflowFloat()
+	if chkOverflow32 {
+		f2 := f
+		if f2 < 0 {
+			f2 = -f
+		}
+		if math.MaxFloat32 < f2 && f2 <= math.MaxFloat64 {
+			decErr("Overflow float32 value: %v", f2)
+		}
+	}
+	d.bdRead = false
+	return
+}
+
+func (d *cborDecDriver) decodeBool() (b bool) {
+	switch d.bd {
+	case cborBdFalse:
+	case cborBdTrue:
+		b = true
+	default:
+		decErr("Invalid single-byte value for bool: %s: %x", msgBadDesc, d.bd)
+	}
+	d.bdRead = false
+	return
+}
+
+func (d *cborDecDriver) readMapLen() (length int) {
+	if d.bd == cborBdIndefiniteMap {
+		length = -1
+	} else if d.bd>>5 == cborMajorMap {
+		length = d.decLen()
+		d.h.checkContainerLen(length)
+	} else {
+		decErr("Invalid descriptor for map: %s: %x", msgBadDesc, d.bd)
+	}
+	d.bdRead = false
+	return
+}
+
+func (d *cborDecDriver) readArrayLen() (length int) {
+	if d.bd == cborBdIndefiniteArray {
+		length = -1
+	} else if d.bd>>5 == cborMajorArray {
+		length = d.decLen()
+		d.h.checkContainerLen(length)
+	} else {
+		decErr("Invalid descriptor for array: %s: %x", msgBadDesc, d.bd)
+	}
+	d.bdRead = false
+	return
+}
+
+// decIndefiniteBytes reads the definite-length chunks of an indefinite-length
+// byte or text string, up to the break byte, appending them to bs.
+func (d *cborDecDriver) decIndefiniteBytes(bs []byte) []byte {
+	major := d.bd >> 5
+	for {
+		d.bd = d.r.readn1()
+		if d.bd == cborBdBreak {
+			break
+		}
+		if d.bd>>5 != major || d.bd&0x1f == 0x1f {
+			decErr("Invalid chunk in indefinite-length string: %s: %x", msgBadDesc, d.bd)
+		}
+		if clen := d.decLen(); clen > 0 {
+			d.h.checkBytesLen(len(bs) + clen)
+			bs = append(bs, d.r.readn(clen)...)
+		}
+	}
+	return bs
+}
+
+func (d *cborDecDriver) checkStringBytes() {
+	if major := d.bd >> 5; major != cborMajorBytes && major != cborMajorText {
+		decErr("Invalid descriptor for string or bytes. Expecting major type 2 or 3: %s: %x", msgBadDesc, d.bd)
+	}
+}
+
+func (d *cborDecDriver) decodeString() (s string) {
+	d.checkStringBytes()
+	if d.bd == cborBdIndefiniteBytes || d.bd == cborBdIndefiniteString {
+		s = string(d.decIndefiniteBytes(nil))
+	} else if clen := d.decLen(); clen > 0 {
+		d.h.checkBytesLen(clen)
+		s = string(d.r.readn(clen))
+	}
+	d.bdRead = false
+	return
+}
+
+// Callers must check if changed=true (to decide whether to replace the one they have)
+func (d *cborDecDriver) decodeBytes(bs []byte) (bsOut []byte, changed bool) {
+	d.checkStringBytes()
+	if d.bd == cborBdIndefiniteBytes || d.bd == cborBdIndefiniteString {
+		bs2 := d.decIndefiniteBytes(nil)
+		if len(bs2) > 0 {
+			if len(bs) == len(bs2) {
+				copy(bs, bs2)
+			} else {
+				bsOut, changed = bs2, true
+			}
+		}
+		d.bdRead = false
+		return
+	}
+	if clen := d.decLen(); clen > 0 {
+		d.h.checkBytesLen(clen)
+		// if no contents in stream, don't update the passed byteslice
+		if len(bs) != clen {
+			// Return changed=true if length of passed slice diff from length of bytes in stream
+			if len(bs) > clen {
+				bs = bs[:clen]
+			} else {
+				bs = make([]byte, clen)
+			}
+			bsOut = bs
+			changed = true
+		}
+		d.r.readb(bs)
+	}
+	d.bdRead = false
+	return
+}
+
+// decodeTag reads the tag number of a tagged value,
+// leaving the descriptor of the tagged value in d.bd.
+func (d *cborDecDriver) decodeTag() (tag uint64) {
+	tag = d.decUint()
+	d.bdRead = false
+	d.initReadNext()
+	return
+}
+
+func (d *cborDecDriver) decodeExt(verifyTag bool, tag byte) (xtag byte, xbs []byte) {
+	xtag = tag
+	if d.bd>>5 == cborMajorTag {
+		xtag2 := d.decodeTag()
+		if (verifyTag && xtag2 != uint64(tag)) || xtag2 > math.MaxUint8 {
+			decErr("Wrong extension tag. Got %v. Expecting: %v", xtag2, tag)
+		}
+		xtag = byte(xtag2)
+	}
+	xbs, _ = d.decodeBytes(nil)
+	return
+}
+
+func (d *cborDecDriver) decodeRaw() []byte {
+	d.initReadNext()
+	bd := d.bd
+	d.r.track()
+	d.swallow()
+	return decRawBytes(bd, d.r.stopTrack())
+}
+
+func (d *cborDecDriver) swallow() {
+	d.swallowDepth(0)
+}
+
+func (d *cborDecDriver) swallowDepth(depth int) {
+	d.initReadNext()
+	// skip any tags, to get to the tagged value
+	for d.bd>>5 == cborMajorTag {
+		d.decUint()
+		d.bdRead = false
+		d.initReadNext()
+	}
+	var n int
+	switch bd := d.bd; bd {
+	case cborBdNil, cborBdUndefined, cborBdFalse, cborBdTrue:
+	case cborBdFloat16:
+		n = 2
+	case cborBdFloat32:
+		n = 4
+	case cborBdFloat64:
+		n = 8
+	case cborBdIndefiniteBytes, cborBdIndefiniteString:
+		for {
+			if d.bd = d.r.readn1(); d.bd == cborBdBreak {
+				break
+			}
+			d.r.skip(d.decLen())
+		}
+	case cborBdIndefiniteArray:
+		d.bdRead = false
+		depth++
+		d.h.checkDepth(depth)
+		for !d.checkBreak() {
+			d.swallowDepth(depth)
+		}
+	case cborBdIndefiniteMap:
+		d.bdRead = false
+		depth++
+		d.h.checkDepth(depth)
+		for !d.checkBreak() {
+			d.swallowDepth(depth)
+			d.swallowDepth(depth)
+		}
+	default:
+		switch bd >> 5 {
+		case cborMajorUint, cborMajorNegInt:
+			d.decUint()
+		case cborMajorBytes, cborMajorText:
+			n = d.decLen()
+		case cborMajorArray:
+			l := d.readArrayLen()
+			depth++
+			d.h.checkDepth(depth)
+			for i := 0; i < l; i++ {
+				d.swallowDepth(depth)
+			}
+		case cborMajorMap:
+			l := d.readMapLen()
+			depth++
+			d.h.checkDepth(depth)
+			for i := 0; i < l; i++ {
+				d.swallowDepth(depth)
+				d.swallowDepth(depth)
+			}
+		default:
+			// simple values
+			d.decUint()
+		}
+	}
+	d.bdRead = false
+	d.r.skip(n)
+}
+
+func (d *cborDecDriver) isBuiltinType(rt uintptr) bool {
+	return rt == timeTypId
+}
+
+func (d *cborDecDriver) decodeBuiltinType(rt uintptr, rv reflect.Value) {
+	switch rt {
+	case timeTypId:
+		rv.Set(reflect.ValueOf(d.decodeTime()))
+	}
+}
+
+// decodeTime decodes a date/time string (tag 0) or an epoch-based time (tag 1).
+// The tag is optional.
+func (d *cborDecDriver) decodeTime() (t time.Time) {
+	if d.bd>>5 == cborMajorTag {
+		if tag := d.decodeTag(); tag != cborTagDateTimeString && tag != cborTagEpochTime {
+			decErr("Invalid tag for time.Time. Expecting 0 or 1. Got: %v", tag)
+		}
+	}
+	switch bd := d.bd; {
+	case bd>>5 == cborMajorText, bd>>5 == cborMajorBytes:
+		var err error
+		if t, err = time.Parse(time.RFC3339Nano, d.decodeString()); err != nil {
+			panic(err)
+		}
+	case bd>>5 == cborMajorUint, bd>>5 == cborMajorNegInt:
+		t = time.Unix(d.decodeInt(64), 0).UTC()
+	case bd == cborBdFloat16, bd == cborBdFloat32, bd == cborBdFloat64:
+		f := d.decodeFloat(false)
+		sec := math.Floor(f)
+		t = time.Unix(int64(sec), int64((f-sec)*1e9)).UTC()
+	default:
+		decErr("Invalid descriptor for time.Time: %s: %x", msgBadDesc, d.bd)
+	}
+	return
+}
+
+// Note: This returns either a primitive (int, bool, etc) for non-containers,
+// or a containerType, or a specific type denoting nil or extension.
+// It is called when a nil interface{} is passed, leaving it up to the DecDriver
+// to introspect the stream and decide how best to decode.
+// It deciphers the value by looking at the stream first.
+func (d *cborDecDriver) decodeNaked() (rv reflect.Value, ctx decodeNakedContext) {
+	d.initReadNext()
+
+	var v interface{}
+
+	switch bd := d.bd; bd {
+	case cborBdNil, cborBdUndefined:
+		ctx = dncNil
+		d.bdRead = false
+	case cborBdFalse, cborBdTrue:
+		v = d.decodeBool()
+	case cborBdFloat16, cborBdFloat32, cborBdFloat64:
+		v = d.decodeFloat(false)
+	default:
+		switch bd >> 5 {
+		case cborMajorUint:
+			v = d.decodeUint(0)
+		case cborMajorNegInt:
+			v = d.decodeInt(0)
+		case cborMajorBytes:
+			bs, _ := d.decodeBytes(nil)
+			if bs == nil {
+				bs = []byte{}
+			}
+			v = bs
+		case cborMajorText:
+			v = d.decodeString()
+		case cborMajorArray:
+			ctx = dncContainer
+			if d.h.SliceType == nil {
+				rv = reflect.New(intfSliceTyp).Elem()
+			} else {
+				rv = reflect.New(d.h.SliceType).Elem()
+			}
+		case cborMajorMap:
+			ctx = dncContainer
+			if d.h.MapType == nil {
+				rv = reflect.MakeMap(mapIntfIntfTyp)
+			} else {
+				rv = reflect.MakeMap(d.h.MapType)
+			}
+		case cborMajorTag:
+			// tags which cannot be kept are skipped in a loop (as in swallowDepth), 
+			// so a long run of tags cannot grow the stack.
+			for {
+				tag := d.decUint()
+				var xf *extTypeTagFn
+				if tag <= math.MaxUint8 {
+					rv, xf = d.h.getDecodeExtForTag(byte(tag))
+				}
+				d.bdRead = false
+				d.initReadNext()
+				if xf != nil {
+					xbs, _ := d.decodeBytes(nil)
+					if fnerr := xf.decode(d.h, rv, xbs); fnerr != nil {
+						panic(fnerr)
+					}
+					break
+				}
+				if tag == cborTagDateTimeString || tag == cborTagEpochTime {
+					v = d.decodeTime()
+					break
+				}
+				if tag <= math.MaxUint8 && d.bd>>5 == cborMajorBytes {
+					xbs, _ := d.decodeBytes(nil)
+					v = RawExt{byte(tag), append(make([]byte, 0, len(xbs)), xbs...)}
+					break
+				}
+				if d.bd>>5 != cborMajorTag {
+					// tag cannot be kept as a RawExt: decode the tagged value as if the tag was not there
+					return d.decodeNaked()
+				}
+			}
+		default:
+			decErr("Nil-Deciphered DecodeValue: %s: hex: %x, dec: %d", msgBadDesc, bd, bd)
+		}
+	}
+	if ctx == dncHandled {
+		d.bdRead = false
+		if v != nil {
+			rv = reflect.ValueOf(v)
+		}
+	}
+	return
+}
+
+// halfFloatToFloatBits converts the bits of an IEEE 754 half-precision float
+// to the bits of the equivalent single-precision float.
+func halfFloatToFloatBits(yy uint16) (d uint32) {
+	y := uint32(yy)
+	s := (y >> 15) & 0x01
+	e := (y >> 10) & 0x1f
+	m := y & 0x03ff
+
+	if e == 0 {
+		if m == 0 {
+			// plus or minus 0
+			return s << 31
+		}
+		// denormalized number: renormalize it
+		for (m & 0x0400) == 0 {
+			m <<= 1
+			e -= 1
+		}
+		e += 1
+		m &^= 0x0400
+	} else if e == 31 {
+		if m == 0 {
+			// Inf
+			return (s << 31) | 0x7f800000
+		}
+		// NaN
+		return (s << 31) | 0x7f800000 | (m << 13)
+	}
+	e = e + (127 - 15)
+	m = m << 13
+	return (s << 31) | (e << 23) | m
+}
@@ -23,17 +23,22 @@ package codec
 import (
 	"bytes"
 	"encoding/gob"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"math"
+	"math/big"
 	"net"
 	"net/rpc"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"reflect"
+	"sort"
 	"strconv"
+	"strings"
 	"testing"
 	"time"
 	"sync/atomic"
@@ -48,6 +53,8 @@ const (
 	testVerifyMapTypeIntfIntf
 	// testVerifySliceIntf
 	testVerifyForPython
+	// like testVerifyMapTypeStrIntf, but positive integers are uint64 (e.g. cbor)
+	testVerifyMapTypeStrIntfPosUint
 )
 
 var (
@@ -69,11 +76,14 @@ var (
 	table              []interface{} // main items we encode
 	tableVerify        []interface{} // we verify encoded things against this after decode
 	tableTestNilVerify []interface{} // for nil interface, use this to verify (rules are different)
+	tableTestNilVerifyPosUint []interface{} // for nil interface, where positive integers decode as uint64
 	tablePythonVerify  []interface{} // for verifying for python, since Python sometimes
 	// will encode a float32 as float64, or large int as uint
 	testRpcInt   = new(TestRpcInt)
 	testMsgpackH = &MsgpackHandle{}
 	testBincH    = &BincHandle{}
+	testJsonH    = &JsonHandle{}
+	testCborH    = &CborHandle{}
 )
 
 func testInitFlags() {
@@ -133,6 +143,215 @@ type TestABC struct {
 	A, B, C string
 }
 
+type TestErrOuter struct {
+	Items []TestABC
+}
+
+type TestTokRow struct {
+	_struct bool `codec:",toarray"`
+	S       string
+	M       map[string]int
+	L       []int
+	ABC     TestABC
+	P       *int
+	B       bool
+	F       float64
+	Bs      []byte
+}
+
+type TestTagKeys struct {
+	A int `codec:"a" json:"ja"`
+	B int `json:"jb,omitempty"`
+	C int `json:"-"`
+	D int
+	E int `json:",omitempty"`
+}
+
+type TestEvent interface {
+	EventName() string
+}
+
+type TestEventA struct{ A int }
+
+func (e TestEventA) EventName() string { return "a" }
+
+type TestEventB struct{ B string }
+
+func (e *TestEventB) EventName() string { return "b" }
+
+func (e *TestEventB) Error() string { return e.B }
+
+type TestEventC struct{}
+
+func (e TestEventC) EventName() string { return "c" }
+
+type TestEvents struct {
+	E  TestEvent
+	Es []TestEvent
+	N  TestEvent
+}
+
+// TestTextKey is an enum which is encoded as text (and can be a map key).
+type TestTextKey int
+
+func (k TestTextKey) MarshalText() ([]byte, error) {
+	return []byte(fmt.Sprintf("k%d", int(k))), nil
+}
+
+func (k *TestTextKey) UnmarshalText(text []byte) error {
+	_, err := fmt.Sscanf(string(text), "k%d", (*int)(k))
+	return err
+}
+
+type TestText struct {
+	IP    net.IP
+	K     TestTextKey
+	PK    *TestTextKey
+	NilPK *TestTextKey
+	M     map[TestTextKey]int
+}
+
+// TestJsonVal only implements json.Marshaler and json.Unmarshaler.
+type TestJsonVal struct {
+	N int
+}
+
+func (x TestJsonVal) MarshalJSON() ([]byte, error) {
+	return []byte(fmt.Sprintf(`{"n":%d}`, x.N)), nil
+}
+
+func (x *TestJsonVal) UnmarshalJSON(data []byte) error {
+	_, err := fmt.Sscanf(string(data), `{"n":%d}`, &x.N)
+	return err
+}
+
+type TestJsonVals struct {
+	V    TestJsonVal
+	P    *TestJsonVal
+	NilP *TestJsonVal
+}
+
+// TestMoney is encoded as a [currency, cents] array, via testMoneyExt.
+type TestMoney struct {
+	Currency string
+	Cents    int64
+}
+
+type TestMonies struct {
+	M    TestMoney
+	P    *TestMoney
+	NilP *TestMoney
+}
+
+type testMoneyExt struct{}
+
+func (_ testMoneyExt) ConvertExt(v interface{}) (interface{}, error) {
+	m := v.(TestMoney)
+	return []interface{}{m.Currency, m.Cents}, nil
+}
+
+func (_ testMoneyExt) UpdateExt(dst interface{}, src interface{}) error {
+	s, ok := src.([]interface{})
+	if !ok || len(s) != 2 {
+		return fmt.Errorf("invalid money: %v", src)
+	}
+	m := dst.(*TestMoney)
+	m.Currency = fmt.Sprintf("%s", s[0])
+	switch n := s[1].(type) {
+	case int64:
+		m.Cents = n
+	case uint64:
+		m.Cents = int64(n)
+	case float64:
+		m.Cents = int64(n)
+	default:
+		return fmt.Errorf("invalid money cents: %T", s[1])
+	}
+	return nil
+}
+
+// testMoneyBytesExt writes the cents (only) as 8 big-endian bytes.
+type testMoneyBytesExt struct{}
+
+func (_ testMoneyBytesExt) WriteExt(v interface{}) ([]byte, error) {
+	bs := make([]byte, 8)
+	bigen.PutUint64(bs, uint64(v.(TestMoney).Cents))
+	return bs, nil
+}
+
+func (_ testMoneyBytesExt) ReadExt(dst interface{}, src []byte) error {
+	dst.(*TestMoney).Cents = int64(bigen.Uint64(src))
+	return nil
+}
+
+type TestExtTypes struct {
+	Ints []int
+	M    TestMoney
+	P    *TestMoney
+	E    error
+	NilE error
+}
+
+// testIntsExt writes a []int as one byte per element.
+type testIntsExt struct{}
+
+func (_ testIntsExt) WriteExt(v interface{}) ([]byte, error) {
+	var bs []byte
+	for _, i := range v.([]int) {
+		bs = append(bs, byte(i))
+	}
+	return bs, nil
+}
+
+func (_ testIntsExt) ReadExt(dst interface{}, src []byte) error {
+	v := make([]int, len(src))
+	for i, b := range src {
+		v[i] = int(b)
+	}
+	*(dst.(*[]int)) = v
+	return nil
+}
+
+// testMoneyPtrExt is registered for *TestMoney, and writes it as "currency cents".
+type testMoneyPtrExt struct{}
+
+func (_ testMoneyPtrExt) WriteExt(v interface{}) ([]byte, error) {
+	m := v.(*TestMoney)
+	return []byte(fmt.Sprintf("%s %d", m.Currency, m.Cents)), nil
+}
+
+func (_ testMoneyPtrExt) ReadExt(dst interface{}, src []byte) error {
+	m := new(TestMoney)
+	*(dst.(**TestMoney)) = m
+	_, err := fmt.Sscanf(string(src), "%s %d", &m.Currency, &m.Cents)
+	return err
+}
+
+// testErrorExt is registered for the error interface, and writes its message.
+type testErrorExt struct{}
+
+func (_ testErrorExt) WriteExt(v interface{}) ([]byte, error) {
+	return []byte(v.(error).Error()), nil
+}
+
+func (_ testErrorExt) ReadExt(dst interface{}, src []byte) error {
+	*(dst.(*error)) = errors.New(string(src))
+	return nil
+}
+
+type TestBig struct {
+	I    *big.Int
+	I2   big.Int
+	F    *big.Float
+	R    *big.Rat
+	NilI *big.Int
+}
+
+type TestRawExt struct {
+	A int
+	X RawExt
+}
+
 type TestRpcInt struct {
 	i int
 }
@@ -155,25 +374,25 @@ func testVerifyVal(v interface{}, arg testVerifyArg) (v2 interface{}) {
 	//  - all floats are float64
 	switch iv := v.(type) {
 	case int8:
-		if arg == testVerifyForPython && iv > 0 {
+		if (arg == testVerifyForPython || arg == testVerifyMapTypeStrIntfPosUint) && iv > 0 {
 			v2 = uint64(iv)
 		} else {
 			v2 = int64(iv)
 		}
 	case int16:
-		if arg == testVerifyForPython && iv > 0 {
+		if (arg == testVerifyForPython || arg == testVerifyMapTypeStrIntfPosUint) && iv > 0 {
 			v2 = uint64(iv)
 		} else {
 			v2 = int64(iv)
 		}
 	case int32:
-		if arg == testVerifyForPython && iv > 0 {
+		if (arg == testVerifyForPython || arg == testVerifyMapTypeStrIntfPosUint) && iv > 0 {
 			v2 = uint64(iv)
 		} else {
 			v2 = int64(iv)
 		}
 	case int64:
-		if arg == testVerifyForPython && iv > 0 {
+		if (arg == testVerifyForPython || arg == testVerifyMapTypeStrIntfPosUint) && iv > 0 {
 			v2 = uint64(iv)
 		} else {
 			v2 = int64(iv)
@@ -204,7 +423,7 @@ func testVerifyVal(v interface{}, arg testVerifyArg) (v2 interface{}) {
 				m2[kj] = kv
 			}
 			v2 = m2
-		case testVerifyMapTypeStrIntf, testVerifyForPython:
+		case testVerifyMapTypeStrIntf, testVerifyForPython, testVerifyMapTypeStrIntfPosUint:
 			m2 := make(map[string]interface{})
 			for kj, kv := range iv {
 				m2[kj] = kv
@@ -225,7 +444,7 @@ func testVerifyVal(v interface{}, arg testVerifyArg) (v2 interface{}) {
 				m2[kj] = testVerifyVal(kv, arg)
 			}
 			v2 = m2
-		case testVerifyMapTypeStrIntf, testVerifyForPython:
+		case testVerifyMapTypeStrIntf, testVerifyForPython, testVerifyMapTypeStrIntfPosUint:
 			m2 := make(map[string]interface{})
 			for kj, kv := range iv {
 				m2[kj] = testVerifyVal(kv, arg)
@@ -269,9 +488,11 @@ func testInit() {
 	}
 
 	testBincH.StructToArray = testStructToArray
+	testJsonH.StructToArray = testStructToArray
+	testCborH.StructToArray = testStructToArray
 	testMsgpackH.StructToArray = testStructToArray
 	testMsgpackH.RawToString = true 
-	//testMsgpackH.AddExt(byteSliceTyp, 0, testMsgpackH.BinaryEncodeExt, testMsgpackH.BinaryDecodeExt)
+	testMsgpackH.AddExt(byteSliceTyp, 0, testMsgpackH.BinaryEncodeExt, testMsgpackH.BinaryDecodeExt)
 	testMsgpackH.AddExt(timeTyp, 1, testMsgpackH.TimeEncodeExt, testMsgpackH.TimeDecodeExt)
 	primitives := []interface{}{
 		int8(-8),
@@ -342,6 +563,7 @@ func testInit() {
 
 	tableVerify = make([]interface{}, len(table))
 	tableTestNilVerify = make([]interface{}, len(table))
+	tableTestNilVerifyPosUint = make([]interface{}, len(table))
 	tablePythonVerify = make([]interface{}, len(table))
 
 	lp := len(primitives)
@@ -373,6 +595,15 @@ func testInit() {
 		av[i] = testVerifyVal(v, testVerifyMapTypeStrIntf)
 	}
 
+	av = tableTestNilVerifyPosUint
+	for i, v := range table {
+		if i > lp+3 {
+			av[i] = skipVerifyVal
+			continue
+		}
+		av[i] = testVerifyVal(v, testVerifyMapTypeStrIntfPosUint)
+	}
+
 	av = tablePythonVerify
 	for i, v := range table {
 		if i > lp+3 {
@@ -531,13 +762,27 @@ func doTestCodecTableOne(t *testing.T, testNil bool, h Handle,
 func testCodecTableOne(t *testing.T, h Handle) {
 	// func TestMsgpackAllExperimental(t *testing.T) {
 	// dopts := testDecOpts(nil, nil, false, true, true),
+	idxTime, numPrim, numMap := 19, 23, 4
+	verify := tableVerify
 	var oldWriteExt, oldRawToString bool
 	switch v := h.(type) {
 	case *MsgpackHandle:
 		oldWriteExt, v.WriteExt = v.WriteExt, true 
 		oldRawToString, v.RawToString = v.RawToString, true 
 	}
-	doTestCodecTableOne(t, false, h, table, tableVerify)
+	if _, ok := h.(*JsonHandle); ok {
+		// json map keys are strings, so the keys of the last map decode as strings.
+		verify = append([]interface{}(nil), tableVerify...)
+		verify[numPrim+numMap] = testVerifyVal(map[interface{}]interface{}{
+			"true":  "true",
+			"8":     false,
+			"false": uint8(0),
+		}, testVerifyMapTypeSame)
+	} else {
+		// json has no time type: a time.Time in an interface{} decodes as a string.
+		// The table is run below without the []interface{} containing time.Time.
+		doTestCodecTableOne(t, false, h, table, verify)
+	}
 	//if true { panic("") }
 	switch v := h.(type) {
 	case *MsgpackHandle:
@@ -545,34 +790,43 @@ func testCodecTableOne(t *testing.T, h Handle) {
 	}
 	// func TestMsgpackAll(t *testing.T) {
 	
-	idxTime, numPrim, numMap := 19, 23, 4
-	
 	//skip []interface{} containing time.Time
-	doTestCodecTableOne(t, false, h, table[:numPrim], tableVerify[:numPrim])
-	doTestCodecTableOne(t, false, h, table[numPrim+1:], tableVerify[numPrim+1:])
+	doTestCodecTableOne(t, false, h, table[:numPrim], verify[:numPrim])
+	doTestCodecTableOne(t, false, h, table[numPrim+1:], verify[numPrim+1:])
 	// func TestMsgpackNilStringMap(t *testing.T) {
 	var oldMapType reflect.Type
+	nilVerify := tableTestNilVerify
 	switch v := h.(type) {
 	case *MsgpackHandle:
 		oldMapType, v.MapType = v.MapType, mapStringIntfTyp
 	case *BincHandle:
 		oldMapType, v.MapType = v.MapType, mapStringIntfTyp
+	case *CborHandle:
+		oldMapType, v.MapType = v.MapType, mapStringIntfTyp
+		nilVerify = tableTestNilVerifyPosUint
+	case *JsonHandle:
+		oldMapType, v.MapType = v.MapType, mapStringIntfTyp
+		nilVerify = tableTestNilVerifyPosUint
 	}
 	//skip time.Time, []interface{} containing time.Time, last map, and newStruc
-	doTestCodecTableOne(t, true, h, table[:idxTime], tableTestNilVerify[:idxTime])
-	doTestCodecTableOne(t, true, h, table[numPrim+1:numPrim+numMap], tableTestNilVerify[numPrim+1:numPrim+numMap])
+	doTestCodecTableOne(t, true, h, table[:idxTime], nilVerify[:idxTime])
+	doTestCodecTableOne(t, true, h, table[numPrim+1:numPrim+numMap], nilVerify[numPrim+1:numPrim+numMap])
 
 	switch v := h.(type) {
 	case *MsgpackHandle:
 		v.MapType = oldMapType
 	case *BincHandle:
 		v.MapType = oldMapType
+	case *CborHandle:
+		v.MapType = oldMapType
+	case *JsonHandle:
+		v.MapType = oldMapType
 	}
 
 	// func TestMsgpackNilIntf(t *testing.T) {
 	
 	//do newTestStruc and last element of map
-	doTestCodecTableOne(t, true, h, table[numPrim+numMap:], tableTestNilVerify[numPrim+numMap:])
+	doTestCodecTableOne(t, true, h, table[numPrim+numMap:], nilVerify[numPrim+numMap:])
 	//TODO? What is this one? 
 	//doTestCodecTableOne(t, true, h, table[17:18], tableTestNilVerify[17:18])
 }
@@ -673,6 +927,250 @@ func testCodecMiscOne(t *testing.T, h Handle) {
 		logT(t, "Not Equal: %v. t2: %v, t3: %v", err, t2, t3)
 		t.FailNow()
 	}
+
+	// test that unknown map keys and extra array elements (including nested
+	// containers) are skipped, leaving the stream at the start of the next value.
+	skipped := []interface{}{
+		nil, true, -7, uint64(300), 1.5, "skipped", []byte{1, 2, 3}, time.Unix(1, 0).UTC(),
+		map[string]interface{}{"x": []interface{}{1, "y", map[string]interface{}{"z": nil}}},
+	}
+	mm = map[string]interface{}{"A": 5, "X": skipped, "C": 333}
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf, h)
+	for _, v := range []interface{}{mm, []interface{}{5, 333, skipped}, "end"} {
+		if err = enc.Encode(v); err != nil {
+			logT(t, "Error encoding %v. Err: %v", v, err)
+			t.FailNow()
+		}
+	}
+	for _, dec := range []*Decoder{
+		NewDecoderBytes(buf.Bytes(), h),
+		NewDecoder(bytes.NewReader(buf.Bytes()), h),
+	} {
+		for i := 0; i < 2; i++ {
+			t2 = ttt{}
+			if err = dec.Decode(&t2); err != nil {
+				logT(t, "Error decoding with unknown fields into &t2. Err: %v", err)
+				t.FailNow()
+			}
+			checkEqualT(t, t2, t3)
+		}
+		var s string
+		if err = dec.Decode(&s); err != nil {
+			logT(t, "Error decoding value after unknown fields. Err: %v", err)
+			t.FailNow()
+		}
+		checkEqualT(t, s, "end")
+	}
+
+	// test that a Reset Encoder/Decoder works like a new one
+	var bs0, bs1 []byte
+	eo := TestErrOuter{Items: []TestABC{{"a1", "b1", "c1"}, {"a2", "b2", "c2"}}}
+	enc = NewEncoderBytes(&bs0, h)
+	checkErrT(t, enc.Encode(eo))
+	buf.Reset()
+	enc.Reset(&buf)
+	checkErrT(t, enc.Encode(eo))
+	checkEqualT(t, buf.Bytes(), bs0)
+	enc.ResetBytes(&bs1)
+	checkErrT(t, enc.Encode(eo))
+	checkEqualT(t, bs1, bs0)
+	dec := NewDecoderBytes(bs0, h)
+	for i := 0; i < 3; i++ {
+		var eo2 TestErrOuter
+		checkErrT(t, dec.Decode(&eo2))
+		checkEqualT(t, eo2, eo)
+		if i == 0 {
+			dec.Reset(bytes.NewReader(bs1))
+		} else {
+			dec.ResetBytes(bs1)
+		}
+	}
+
+	testCodecErrors(t, h)
+	testCodecTokenReader(t, h)
+	testCodecTokenWriter(t, h)
+}
+
+func testCodecErrors(t *testing.T, h Handle) {
+	items := []interface{}{}
+	for i := 0; i < 4; i++ {
+		items = append(items, map[string]interface{}{"A": "a", "B": "b"})
+	}
+	bs, err := testMarshal(map[string]interface{}{"Items": items}, h)
+	checkErrT(t, err)
+
+	// an incomplete value is an unexpected EOF. No value at all is an EOF.
+	var v TestErrOuter
+	err = testUnmarshal(&v, bs[:len(bs)-1], h)
+	derr, ok := err.(*DecodeError)
+	if !ok || derr.Err != io.ErrUnexpectedEOF {
+		logT(t, "Expecting *DecodeError with io.ErrUnexpectedEOF. Got: %v", err)
+		t.FailNow()
+	}
+	if err = testUnmarshal(&v, nil, h); err != io.EOF {
+		logT(t, "Expecting io.EOF. Got: %v", err)
+		t.FailNow()
+	}
+
+	// decode errors report the offset, path and type
+	items[3] = map[string]interface{}{"A": "a", "B": 5}
+	bs, err = testMarshal(map[string]interface{}{"Items": items}, h)
+	checkErrT(t, err)
+	v = TestErrOuter{}
+	err = testUnmarshal(&v, bs, h)
+	if derr, ok = err.(*DecodeError); !ok {
+		logT(t, "Expecting *DecodeError. Got: %T: %v", err, err)
+		t.FailNow()
+	}
+	logT(t, "DecodeError: %v", derr)
+	checkEqualT(t, derr.Path, "TestErrOuter.Items[3].B")
+	checkEqualT(t, derr.Type, reflect.TypeOf(""))
+	if derr.Offset <= 0 || derr.Offset > int64(len(bs)) {
+		logT(t, "DecodeError offset: %v out of range: (0, %v]", derr.Offset, len(bs))
+		t.FailNow()
+	}
+
+	// encode errors report the path and type
+	_, err = testMarshal(map[string]interface{}{"x": []interface{}{1, make(chan int)}}, h)
+	eerr, ok := err.(*EncodeError)
+	if !ok {
+		logT(t, "Expecting *EncodeError. Got: %T: %v", err, err)
+		t.FailNow()
+	}
+	logT(t, "EncodeError: %v", eerr)
+	checkEqualT(t, eerr.Path, `["x"][1]`)
+	checkEqualT(t, eerr.Type, reflect.TypeOf(make(chan int)))
+}
+
+func testCodecTokenReader(t *testing.T, h Handle) {
+	bs, err := testMarshal([]interface{}{"x", map[string]int{"a": 1}, []int{1, 2, 3}, TestABC{"a", "b", "c"}}, h)
+	checkErrT(t, err)
+	bs2, err := testMarshal(uint(5), h)
+	checkErrT(t, err)
+	bs = append(bs, bs2...)
+
+	dec := NewDecoderBytes(bs, h)
+	vt, err := dec.NextType()
+	checkErrT(t, err)
+	checkEqualT(t, vt, ValueTypeArray)
+	_, err = dec.ReadArrayStart()
+	checkErrT(t, err)
+	more, err := dec.More()
+	checkErrT(t, err)
+	checkEqualT(t, more, true)
+	var s string
+	checkErrT(t, dec.Decode(&s))
+	checkEqualT(t, s, "x")
+
+	// read the map, one key and value at a time
+	more, _ = dec.More()
+	checkEqualT(t, more, true)
+	vt, err = dec.NextType()
+	checkErrT(t, err)
+	checkEqualT(t, vt, ValueTypeMap)
+	_, err = dec.ReadMapStart()
+	checkErrT(t, err)
+	var n, sum int
+	for more, err = dec.More(); more && err == nil; more, err = dec.More() {
+		checkErrT(t, dec.Decode(&s))
+		checkEqualT(t, s, "a")
+		checkErrT(t, dec.Decode(&n))
+		checkEqualT(t, n, 1)
+	}
+	checkErrT(t, err)
+
+	// an element not read is skipped. An element can be skipped explicitly.
+	more, _ = dec.More()
+	checkEqualT(t, more, true)
+	_, err = dec.ReadArrayStart()
+	checkErrT(t, err)
+	for more, err = dec.More(); more && err == nil; more, err = dec.More() {
+		checkErrT(t, dec.Decode(&n))
+		sum += n
+		if more, err = dec.More(); !more || err != nil {
+			break
+		}
+	}
+	checkErrT(t, err)
+	checkEqualT(t, sum, 4)
+	more, _ = dec.More()
+	checkEqualT(t, more, true)
+	checkErrT(t, dec.Skip())
+	more, err = dec.More()
+	checkErrT(t, err)
+	checkEqualT(t, more, false)
+
+	// reading continues after the array, until the end of the stream.
+	if _, err = dec.More(); err == nil {
+		logT(t, "Expecting error calling More when no array or map is being read")
+		t.FailNow()
+	}
+	checkErrT(t, dec.Decode(&n))
+	checkEqualT(t, n, 5)
+	if _, err = dec.NextType(); err != io.EOF {
+		logT(t, "Expecting io.EOF. Got: %v", err)
+		t.FailNow()
+	}
+
+	// More must be called before reading an element.
+	dec.ResetBytes(bs)
+	_, err = dec.ReadArrayStart()
+	checkErrT(t, err)
+	if err = dec.Decode(&s); err == nil {
+		logT(t, "Expecting error reading element before calling More")
+		t.FailNow()
+	}
+}
+
+func testCodecTokenWriter(t *testing.T, h Handle) {
+	var bs []byte
+	enc := NewEncoderBytes(&bs, h)
+	checkErrT(t, enc.WriteArrayStart(8))
+	checkErrT(t, enc.WriteString("x"))
+	checkErrT(t, enc.WriteMapStart(2))
+	for i, k := range []string{"a", "b"} {
+		checkErrT(t, enc.WriteString(k))
+		checkErrT(t, enc.WriteInt(int64(i)))
+	}
+	checkErrT(t, enc.WriteEnd())
+	checkErrT(t, enc.WriteArrayStart(3))
+	for i := 1; i <= 3; i++ {
+		checkErrT(t, enc.Encode(i))
+	}
+	checkErrT(t, enc.WriteEnd())
+	checkErrT(t, enc.Encode(TestABC{"a", "b", "c"}))
+	checkErrT(t, enc.WriteNil())
+	checkErrT(t, enc.WriteBool(true))
+	checkErrT(t, enc.WriteFloat64(1.5))
+	checkErrT(t, enc.WriteBytes([]byte("ab")))
+	checkErrT(t, enc.WriteEnd())
+	checkErrT(t, enc.WriteUint(5))
+
+	var v TestTokRow
+	dec := NewDecoderBytes(bs, h)
+	checkErrT(t, dec.Decode(&v))
+	checkEqualT(t, v, TestTokRow{S: "x", M: map[string]int{"a": 0, "b": 1}, L: []int{1, 2, 3},
+		ABC: TestABC{"a", "b", "c"}, B: true, F: 1.5, Bs: []byte("ab")})
+	var n uint
+	checkErrT(t, dec.Decode(&n))
+	checkEqualT(t, n, uint(5))
+
+	// the number of elements written must match the length
+	enc.ResetBytes(&bs)
+	checkErrT(t, enc.WriteArrayStart(1))
+	checkErrT(t, enc.WriteNil())
+	if err := enc.WriteNil(); err == nil {
+		logT(t, "Expecting error writing more elements than the array length")
+		t.FailNow()
+	}
+	enc.ResetBytes(&bs)
+	checkErrT(t, enc.WriteMapStart(1))
+	checkErrT(t, enc.WriteString("a"))
+	if err := enc.WriteEnd(); err == nil {
+		logT(t, "Expecting error ending a map before all entries are written")
+		t.FailNow()
+	}
 }
 
 func doTestRpcOne(t *testing.T, rr Rpc, h Handle, doRequest bool, exitSleepMs time.Duration,
@@ -893,6 +1391,830 @@ func doTestMsgpackRpcSpecPythonClientToGoSvc(t *testing.T) {
 }
 
 
+func doTestCanonical(t *testing.T, h Handle) {
+	// map is encoded with keys sorted: nil < bool < numbers < strings < others.
+	// Compare it against a list of the same keys and values in the expected order.
+	m := map[interface{}]interface{}{
+		"b": 1, "a": 2, int64(2): 3, int8(-1): 4, uint(1): 5, 1.5: 6,
+		true: 7, false: 8, [2]int{1, 2}: 9, "": 10, -1.5: 11, nil: 12,
+	}
+	ordered := []interface{}{
+		nil, 12, false, 8, true, 7, -1.5, 11, int8(-1), 4, uint(1), 5, 1.5, 6, int64(2), 3,
+		"", 10, "a", 2, "b", 1, [2]int{1, 2}, 9,
+	}
+	bs0, err := testMarshal(m, h)
+	checkErrT(t, err)
+	for i := 0; i < 8; i++ {
+		bs, err := testMarshal(m, h)
+		checkErrT(t, err)
+		checkEqualT(t, bs, bs0)
+	}
+	var body []byte
+	for _, v := range ordered {
+		bs, err := testMarshal(v, h)
+		checkErrT(t, err)
+		body = append(body, bs...)
+	}
+	// skip the map header
+	if len(bs0) < len(body) {
+		logT(t, "Canonical map encoding too short: %v", bs0)
+		t.FailNow()
+	}
+	checkEqualT(t, bs0[len(bs0)-len(body):], body)
+
+	// numbers are encoded the same, regardless of their type
+	for _, vs := range [][]interface{}{
+		{int64(1), uint8(1), int(1), uint64(1)},
+		{int64(200), uint16(200), int16(200)},
+		{int32(-5), int64(-5)},
+		{float32(1.5), float64(1.5)},
+		{float32(0), float64(0)},
+	} {
+		bs0, err := testMarshal(vs[0], h)
+		checkErrT(t, err)
+		for _, v := range vs[1:] {
+			bs, err := testMarshal(v, h)
+			checkErrT(t, err)
+			checkEqualT(t, bs, bs0)
+		}
+	}
+}
+
+func TestMsgpackCanonical(t *testing.T) {
+	doTestCanonical(t, &MsgpackHandle{EncodeOptions: EncodeOptions{Canonical: true}})
+}
+
+func TestBincCanonical(t *testing.T) {
+	doTestCanonical(t, &BincHandle{EncodeOptions: EncodeOptions{Canonical: true}})
+}
+
+// doTestStructTagKeys checks that h (configured with TypeInfos for codec then json tags)
+// uses the json tag of a field without a codec tag.
+func doTestStructTagKeys(t *testing.T, h Handle) {
+	checkKeys := func(v TestTagKeys, keys ...string) {
+		bs, err := testMarshal(v, h)
+		checkErrT(t, err)
+		var m map[string]interface{}
+		checkErrT(t, testUnmarshal(&m, bs, h))
+		mkeys := make([]string, 0, len(m))
+		for k := range m {
+			mkeys = append(mkeys, k)
+		}
+		sort.Strings(mkeys)
+		checkEqualT(t, mkeys, keys)
+
+		var v2 TestTagKeys
+		checkErrT(t, testUnmarshal(&v2, bs, h))
+		v.C = 0
+		checkEqualT(t, v2, v)
+	}
+	checkKeys(TestTagKeys{1, 0, 3, 4, 0}, "D", "a")
+	checkKeys(TestTagKeys{1, 2, 3, 4, 5}, "D", "E", "a", "jb")
+}
+
+func TestMsgpackStructTagKeys(t *testing.T) {
+	doTestStructTagKeys(t, &MsgpackHandle{BasicHandle: BasicHandle{TypeInfos: NewTypeInfos([]string{"codec", "json"})}})
+}
+
+func TestJsonStructTagKeys(t *testing.T) {
+	doTestStructTagKeys(t, &JsonHandle{BasicHandle: BasicHandle{TypeInfos: NewTypeInfos([]string{"codec", "json"})}})
+}
+
+func TestFieldNaming(t *testing.T) {
+	for _, v := range []struct{ in, snake, kebab, lowerCamel string }{
+		{"UserID", "user_id", "user-id", "userID"},
+		{"HTTPServer2Addr", "http_server2_addr", "http-server2-addr", "httpServer2Addr"},
+		{"A", "a", "a", "a"},
+		{"ID", "id", "id", "id"},
+		{"Name", "name", "name", "name"},
+	} {
+		checkEqualT(t, SnakeCase(v.in), v.snake)
+		checkEqualT(t, KebabCase(v.in), v.kebab)
+		checkEqualT(t, LowerCamelCase(v.in), v.lowerCamel)
+	}
+
+	// untagged fields are named by the policy. Tagged names are kept.
+	type T struct {
+		UserID   int
+		FullName string `codec:",omitempty"`
+		Other    int    `codec:"Other"`
+	}
+	h := &MsgpackHandle{BasicHandle: BasicHandle{TypeInfos: NewTypeInfosNaming([]string{"codec"}, SnakeCase)}}
+	bs, err := testMarshal(T{1, "x", 2}, h)
+	checkErrT(t, err)
+	var m map[string]interface{}
+	checkErrT(t, testUnmarshal(&m, bs, h))
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	checkEqualT(t, keys, []string{"Other", "full_name", "user_id"})
+
+	// keys are matched ignoring case, only if configured
+	bs, err = testMarshal(map[string]interface{}{"USER_ID": 3, "full_name": "y", "other": 4}, h)
+	checkErrT(t, err)
+	var v T
+	checkErrT(t, testUnmarshal(&v, bs, h))
+	checkEqualT(t, v, T{0, "y", 0})
+	h.CaseInsensitiveKeys = true
+	v = T{}
+	checkErrT(t, testUnmarshal(&v, bs, h))
+	checkEqualT(t, v, T{3, "y", 4})
+}
+
+func TestStructFieldAliases(t *testing.T) {
+	type T struct {
+		UserID int    `codec:"user_id,alias=uid|userId"`
+		Name   string `codec:",omitempty,alias=n"`
+	}
+	h := &MsgpackHandle{DecodeOptions: DecodeOptions{ErrorIfNoField: true}}
+	// encoding uses the primary name
+	bs, err := testMarshal(T{1, "x"}, h)
+	checkErrT(t, err)
+	var m map[string]interface{}
+	checkErrT(t, testUnmarshal(&m, bs, h))
+	if _, ok := m["user_id"]; !ok || len(m) != 2 {
+		logT(t, "Expecting keys user_id and Name. Got: %v", m)
+		t.FailNow()
+	}
+	// decoding matches the primary name or any alias
+	for _, k := range []string{"user_id", "uid", "userId"} {
+		bs, err = testMarshal(map[string]interface{}{k: 5, "n": "y"}, h)
+		checkErrT(t, err)
+		var v T
+		checkErrT(t, testUnmarshal(&v, bs, h))
+		checkEqualT(t, v, T{5, "y"})
+	}
+}
+
+func doTestUnknownFields(t *testing.T, h Handle) {
+	type T struct {
+		A    int
+		Rest map[string]interface{} `codec:",unknown"`
+	}
+	bs, err := testMarshal(map[string]interface{}{"A": 1, "x": "y", "z": []interface{}{"p", "q"}}, h)
+	checkErrT(t, err)
+	var v T
+	checkErrT(t, testUnmarshal(&v, bs, h))
+	checkEqualT(t, v.A, 1)
+	checkEqualT(t, len(v.Rest), 2)
+	checkEqualT(t, v.Rest["x"], "y")
+
+	// the unknown keys are written back out (and a key matching a field is not)
+	v.Rest["A"] = 5
+	bs, err = testMarshal(v, h)
+	checkErrT(t, err)
+	var v2 T
+	checkErrT(t, testUnmarshal(&v2, bs, h))
+	delete(v.Rest, "A")
+	checkEqualT(t, v2, v)
+
+	type T2 struct {
+		Rest map[int]interface{} `codec:",unknown"`
+	}
+	if _, err = testMarshal(T2{}, h); err == nil {
+		logT(t, "Expecting error for unknown field which is not a map with string keys")
+		t.FailNow()
+	}
+
+	type T3 struct {
+		_struct bool                   `codec:",toarray"`
+		Rest    map[string]interface{} `codec:",unknown"`
+	}
+	if _, err = testMarshal(T3{}, h); err == nil {
+		logT(t, "Expecting error for unknown field in a toarray struct")
+		t.FailNow()
+	}
+}
+
+func TestMsgpackUnknownFields(t *testing.T) {
+	doTestUnknownFields(t, &MsgpackHandle{RawToString: true})
+	// a struct with an unknown field is encoded as a map even if StructToArray is set
+	h := &MsgpackHandle{RawToString: true}
+	h.StructToArray = true
+	doTestUnknownFields(t, h)
+}
+
+func TestJsonUnknownFields(t *testing.T) {
+	doTestUnknownFields(t, testJsonH)
+}
+
+func doTestRaw(t *testing.T, h Handle) {
+	type envelope struct {
+		Type string
+		Body Raw
+	}
+	type typedEnvelope struct {
+		Type string
+		Body TestABC
+	}
+	body := TestABC{"aa", "bb", "cc"}
+	bs, err := testMarshal(map[string]interface{}{"Type": "abc", "Body": body}, h)
+	checkErrT(t, err)
+	for _, useIo := range []bool{false, true} {
+		var v envelope
+		if useIo {
+			checkErrT(t, NewDecoder(bytes.NewReader(bs), h).Decode(&v))
+		} else {
+			checkErrT(t, NewDecoderBytes(bs, h).Decode(&v))
+		}
+		checkEqualT(t, v.Type, "abc")
+		var body2 TestABC
+		checkErrT(t, testUnmarshal(&body2, v.Body, h))
+		checkEqualT(t, body2, body)
+
+		// a Raw is written verbatim
+		bs2, err := testMarshal(v, h)
+		checkErrT(t, err)
+		var v2 typedEnvelope
+		checkErrT(t, testUnmarshal(&v2, bs2, h))
+		checkEqualT(t, v2, typedEnvelope{"abc", body})
+	}
+
+	// a long value read from an io.Reader is kept whole
+	bs, err = testMarshal(strings.Repeat("x", 2000), h)
+	checkErrT(t, err)
+	var r Raw
+	checkErrT(t, NewDecoder(bytes.NewReader(bs), h).Decode(&r))
+	checkEqualT(t, []byte(r), bs)
+
+	// each Raw holds exactly the bytes of its value
+	bs, err = testMarshal([]interface{}{1, 22, "x", nil, []int{3}}, h)
+	checkErrT(t, err)
+	var rs []Raw
+	checkErrT(t, testUnmarshal(&rs, bs, h))
+	checkEqualT(t, len(rs), 5)
+	checkEqualT(t, rs[3], Raw(nil))
+	for i, v := range []interface{}{1, 22, "x"} {
+		bs2, err := testMarshal(v, h)
+		checkErrT(t, err)
+		checkEqualT(t, []byte(rs[i]), bs2)
+	}
+
+	// unknown fields can be kept as Raw, and are re-emitted unchanged
+	type T struct {
+		Type string
+		Rest map[string]Raw `codec:",unknown"`
+	}
+	bs, err = testMarshal(map[string]interface{}{"Type": "abc", "Body": body}, h)
+	checkErrT(t, err)
+	var v T
+	checkErrT(t, testUnmarshal(&v, bs, h))
+	bs2, err := testMarshal(v, h)
+	checkErrT(t, err)
+	var v2 typedEnvelope
+	checkErrT(t, testUnmarshal(&v2, bs2, h))
+	checkEqualT(t, v2, typedEnvelope{"abc", body})
+}
+
+func TestMsgpackRaw(t *testing.T) {
+	doTestRaw(t, testMsgpackH)
+}
+
+func TestBincRaw(t *testing.T) {
+	doTestRaw(t, testBincH)
+
+	// symbols in a Raw are written as strings, so the Raw can be decoded on its own,
+	// even if the symbols were first seen (and written in full) before it.
+	type item struct {
+		Name, Value string
+	}
+	type envelope struct {
+		First item
+		Body  Raw
+	}
+	body := []item{{"n1", "v1"}, {"n2", "v2"}}
+	bs, err := testMarshal(map[string]interface{}{"First": item{}, "Body": body}, testBincH)
+	checkErrT(t, err)
+	var v envelope
+	checkErrT(t, testUnmarshal(&v, bs, testBincH))
+	var body2 []item
+	checkErrT(t, testUnmarshal(&body2, v.Body, testBincH))
+	checkEqualT(t, body2, body)
+}
+
+func TestCborRaw(t *testing.T) {
+	doTestRaw(t, testCborH)
+}
+
+func TestJsonRaw(t *testing.T) {
+	doTestRaw(t, testJsonH)
+}
+
+func doTestRegisteredTypes(t *testing.T, h Handle, bh *BasicHandle) {
+	eventTyp := reflect.TypeOf((*TestEvent)(nil)).Elem()
+	checkErrT(t, bh.RegisterType(eventTyp, "a", TestEventA{}))
+	checkErrT(t, bh.RegisterTypeTag(eventTyp, 2, &TestEventB{}))
+	if err := bh.RegisterType(eventTyp, "a2", TestEventA{}); err == nil {
+		logT(t, "Expecting error registering a type twice")
+		t.FailNow()
+	}
+	if err := bh.RegisterType(reflect.TypeOf(TestEventA{}), "a", TestEventA{}); err == nil {
+		logT(t, "Expecting error registering a type for a non-interface type")
+		t.FailNow()
+	}
+	if err := bh.RegisterType(reflect.TypeOf((*error)(nil)).Elem(), "a", TestEventA{}); err == nil {
+		logT(t, "Expecting error registering a type for an interface it does not implement")
+		t.FailNow()
+	}
+
+	v := TestEvents{E: &TestEventB{"x"}, Es: []TestEvent{TestEventA{1}, &TestEventB{"y"}, nil}}
+	bs, err := testMarshal(v, h)
+	checkErrT(t, err)
+	var v2 TestEvents
+	checkErrT(t, testUnmarshal(&v2, bs, h))
+	checkEqualT(t, v2, v)
+
+	// decoding into a nil interface
+	var e TestEvent
+	bs, err = testMarshal(v.Es, h)
+	checkErrT(t, err)
+	var es []TestEvent
+	checkErrT(t, testUnmarshal(&es, bs, h))
+	checkEqualT(t, es, v.Es)
+	bs, err = testMarshal(&v.E, h)
+	checkErrT(t, err)
+	checkErrT(t, testUnmarshal(&e, bs, h))
+	checkEqualT(t, e, v.E)
+
+	// an unregistered type cannot be encoded in the interface
+	if _, err = testMarshal(TestEvents{E: TestEventC{}}, h); err == nil {
+		logT(t, "Expecting error encoding unregistered type")
+		t.FailNow()
+	}
+
+	// other interfaces implemented by a registered type (e.g. error) are not affected
+	type errs struct {
+		Err  error
+		Err2 error
+	}
+	bs, err = testMarshal(errs{errors.New("x"), &TestEventB{"z"}}, h)
+	checkErrT(t, err)
+	var m map[string]interface{}
+	checkErrT(t, testUnmarshal(&m, bs, h))
+	checkEqualT(t, len(m), 2)
+	if _, ok := m["Err2"].([]interface{}); ok {
+		logT(t, "Expecting error not to be encoded as a registered type. Got: %v", m["Err2"])
+		t.FailNow()
+	}
+}
+
+func TestMsgpackRegisteredTypes(t *testing.T) {
+	h := &MsgpackHandle{RawToString: true}
+	doTestRegisteredTypes(t, h, &h.BasicHandle)
+}
+
+func TestBincRegisteredTypes(t *testing.T) {
+	h := &BincHandle{}
+	doTestRegisteredTypes(t, h, &h.BasicHandle)
+}
+
+func TestCborRegisteredTypes(t *testing.T) {
+	h := &CborHandle{}
+	doTestRegisteredTypes(t, h, &h.BasicHandle)
+}
+
+func TestJsonRegisteredTypes(t *testing.T) {
+	h := &JsonHandle{}
+	doTestRegisteredTypes(t, h, &h.BasicHandle)
+}
+
+func doTestTextMarshal(t *testing.T, h Handle) {
+	k := TestTextKey(3)
+	v := TestText{IP: net.IPv4(10, 0, 0, 1), K: 2, PK: &k, M: map[TestTextKey]int{1: 10, 2: 20}}
+	bs, err := testMarshal(v, h)
+	checkErrT(t, err)
+	var v2 TestText
+	checkErrT(t, testUnmarshal(&v2, bs, h))
+	checkEqualT(t, v2, v)
+
+	// values and map keys are encoded as strings
+	var m map[string]interface{}
+	checkErrT(t, testUnmarshal(&m, bs, h))
+	checkEqualT(t, fmt.Sprintf("%s", m["IP"]), "10.0.0.1")
+	checkEqualT(t, fmt.Sprintf("%s", m["K"]), "k2")
+	bs, err = testMarshal(v.M, h)
+	checkErrT(t, err)
+	var m2 map[string]int
+	checkErrT(t, testUnmarshal(&m2, bs, h))
+	checkEqualT(t, m2, map[string]int{"k1": 10, "k2": 20})
+}
+
+func TestMsgpackTextMarshal(t *testing.T) {
+	doTestTextMarshal(t, testMsgpackH)
+}
+
+func TestBincTextMarshal(t *testing.T) {
+	doTestTextMarshal(t, testBincH)
+}
+
+func TestCborTextMarshal(t *testing.T) {
+	doTestTextMarshal(t, testCborH)
+}
+
+func TestJsonTextMarshal(t *testing.T) {
+	doTestTextMarshal(t, testJsonH)
+}
+
+func doTestJsonMarshal(t *testing.T, h Handle, useJsonMarshaler *bool) {
+	v := TestJsonVals{V: TestJsonVal{1}, P: &TestJsonVal{2}}
+	// off by default: encoded as a struct
+	bs, err := testMarshal(v, h)
+	checkErrT(t, err)
+	var m map[string]interface{}
+	checkErrT(t, testUnmarshal(&m, bs, h))
+	if _, ok := m["V"].(map[interface{}]interface{}); !ok {
+		if _, ok = m["V"].(map[string]interface{}); !ok {
+			logT(t, "Expecting V encoded as a map. Got: %T", m["V"])
+			t.FailNow()
+		}
+	}
+
+	*useJsonMarshaler = true
+	defer func() { *useJsonMarshaler = false }()
+	bs, err = testMarshal(v, h)
+	checkErrT(t, err)
+	var v2 TestJsonVals
+	checkErrT(t, testUnmarshal(&v2, bs, h))
+	checkEqualT(t, v2, v)
+
+	m = nil
+	checkErrT(t, testUnmarshal(&m, bs, h))
+	if _, ok := h.(*JsonHandle); ok {
+		checkEqualT(t, fmt.Sprintf("%v", m["V"]), "map[n:1]")
+	} else {
+		checkEqualT(t, fmt.Sprintf("%s", m["V"]), `{"n":1}`)
+	}
+}
+
+func TestMsgpackJsonMarshal(t *testing.T) {
+	doTestJsonMarshal(t, testMsgpackH, &testMsgpackH.UseJsonMarshaler)
+}
+
+func TestBincJsonMarshal(t *testing.T) {
+	doTestJsonMarshal(t, testBincH, &testBincH.UseJsonMarshaler)
+}
+
+func TestCborJsonMarshal(t *testing.T) {
+	doTestJsonMarshal(t, testCborH, &testCborH.UseJsonMarshaler)
+}
+
+func TestJsonJsonMarshal(t *testing.T) {
+	doTestJsonMarshal(t, testJsonH, &testJsonH.UseJsonMarshaler)
+}
+
+func doTestInterfaceExt(t *testing.T, h Handle, naked bool) {
+	type extSetter interface {
+		SetExt(rt reflect.Type, tag byte, ext interface{}) error
+	}
+	xh := h.(extSetter)
+	moneyTyp := reflect.TypeOf(TestMoney{})
+	checkErrT(t, xh.SetExt(moneyTyp, 12, testMoneyExt{}))
+	defer xh.SetExt(moneyTyp, 12, nil)
+	if err := xh.SetExt(moneyTyp, 12, 1); err == nil {
+		logT(t, "Expecting error registering an extension which is neither BytesExt nor InterfaceExt")
+		failT(t)
+	}
+
+	v := TestMonies{M: TestMoney{"USD", 1250}, P: &TestMoney{"EUR", 99}}
+	bs, err := testMarshal(v, h)
+	checkErrT(t, err)
+	var v2 TestMonies
+	checkErrT(t, testUnmarshal(&v2, bs, h))
+	checkEqualT(t, v2, v)
+
+	if naked {
+		// the ext tag tells the decoder what type to decode into
+		bs, err = testMarshal(v.M, h)
+		checkErrT(t, err)
+		var iv interface{}
+		checkErrT(t, testUnmarshal(&iv, bs, h))
+		checkEqualT(t, iv, v.M)
+	}
+
+	checkErrT(t, xh.SetExt(moneyTyp, 12, testMoneyBytesExt{}))
+	bs, err = testMarshal(v, h)
+	checkErrT(t, err)
+	v2 = TestMonies{}
+	checkErrT(t, testUnmarshal(&v2, bs, h))
+	checkEqualT(t, v2, TestMonies{M: TestMoney{Cents: 1250}, P: &TestMoney{Cents: 99}})
+}
+
+func TestMsgpackInterfaceExt(t *testing.T) {
+	doTestInterfaceExt(t, testMsgpackH, false)
+	doTestInterfaceExt(t, &MsgpackHandle{WriteExt: true}, true)
+}
+
+func TestBincInterfaceExt(t *testing.T) {
+	doTestInterfaceExt(t, testBincH, true)
+}
+
+func TestCborInterfaceExt(t *testing.T) {
+	doTestInterfaceExt(t, testCborH, true)
+}
+
+func TestJsonInterfaceExt(t *testing.T) {
+	doTestInterfaceExt(t, testJsonH, false)
+}
+
+func doTestRawExt(t *testing.T, h Handle) {
+	v := []interface{}{RawExt{9, []byte("abc")}, RawExt{10, []byte{}}}
+	bs, err := testMarshal(v, h)
+	checkErrT(t, err)
+	// unregistered tags are decoded into a RawExt, and written back unchanged
+	var v2 interface{}
+	checkErrT(t, testUnmarshal(&v2, bs, h))
+	checkEqualT(t, v2, v)
+	bs2, err := testMarshal(v2, h)
+	checkErrT(t, err)
+	checkEqualT(t, bs2, bs)
+
+	// decoding into a RawExt keeps the extension as is, even if its tag is registered
+	bs, err = testMarshal(TestRawExt{1, RawExt{2, []byte{1, 2, 3}}}, h)
+	checkErrT(t, err)
+	var v3 TestRawExt
+	checkErrT(t, testUnmarshal(&v3, bs, h))
+	checkEqualT(t, v3, TestRawExt{1, RawExt{2, []byte{1, 2, 3}}})
+}
+
+func TestMsgpackRawExt(t *testing.T) {
+	doTestRawExt(t, &MsgpackHandle{WriteExt: true})
+}
+
+func TestBincRawExt(t *testing.T) {
+	doTestRawExt(t, testBincH)
+}
+
+func TestJsonRawExt(t *testing.T) {
+	// only the data is written (as base64), and the tag is kept from the value decoded into
+	v := TestRawExt{1, RawExt{2, []byte{1, 2, 3}}}
+	bs, err := testMarshal(v, testJsonH)
+	checkErrT(t, err)
+	checkEqualT(t, string(bs), `{"A":1,"X":"AQID"}`)
+	v2 := TestRawExt{X: RawExt{Tag: 2}}
+	checkErrT(t, testUnmarshal(&v2, bs, testJsonH))
+	checkEqualT(t, v2, v)
+}
+
+func doTestExtTypes(t *testing.T, h Handle) {
+	type extSetter interface {
+		SetExt(rt reflect.Type, tag byte, ext interface{}) error
+		RemoveExt(rt reflect.Type)
+	}
+	xh := h.(extSetter)
+	intsTyp := reflect.TypeOf([]int(nil))
+	moneyPtrTyp := reflect.TypeOf((*TestMoney)(nil))
+	errorTyp := reflect.TypeOf((*error)(nil)).Elem()
+	checkErrT(t, xh.SetExt(intsTyp, 20, testIntsExt{}))
+	checkErrT(t, xh.SetExt(moneyPtrTyp, 21, testMoneyPtrExt{}))
+	checkErrT(t, xh.SetExt(errorTyp, 22, testErrorExt{}))
+	defer func() {
+		xh.RemoveExt(intsTyp)
+		xh.RemoveExt(moneyPtrTyp)
+		xh.RemoveExt(errorTyp)
+	}()
+
+	v := TestExtTypes{Ints: []int{1, 2, 3}, M: TestMoney{"USD", 1}, P: &TestMoney{"EUR", 2}, E: errors.New("boom")}
+	bs, err := testMarshal(v, h)
+	checkErrT(t, err)
+	var v2 TestExtTypes
+	checkErrT(t, testUnmarshal(&v2, bs, h))
+	checkEqualT(t, v2, v)
+
+	// only the pointer is an extension: the value is encoded as a struct
+	var m map[string]interface{}
+	checkErrT(t, testUnmarshal(&m, bs, h))
+	checkEqualT(t, m["P"], v.P)
+	if _, ok := m["M"].(TestMoney); ok {
+		logT(t, "Expecting M not encoded as an extension")
+		failT(t)
+	}
+
+	// the tag of an interface extension decodes into a value of the interface type
+	bs, err = testMarshal(v.E, h)
+	checkErrT(t, err)
+	var iv interface{}
+	checkErrT(t, testUnmarshal(&iv, bs, h))
+	checkEqualT(t, iv, v.E)
+}
+
+func TestMsgpackExtTypes(t *testing.T) {
+	doTestExtTypes(t, &MsgpackHandle{WriteExt: true})
+}
+
+func TestBincExtTypes(t *testing.T) {
+	doTestExtTypes(t, testBincH)
+}
+
+func TestCborExtTypes(t *testing.T) {
+	doTestExtTypes(t, testCborH)
+}
+
+func doTestBig(t *testing.T, h Handle, naked bool) {
+	checkBig := func(v1, v2 interface{}) {
+		if fmt.Sprintf("%T %v", v1, v1) != fmt.Sprintf("%T %v", v2, v2) {
+			logT(t, "Not Equal: %T %v, %T %v", v1, v1, v2, v2)
+			failT(t)
+		}
+	}
+	for _, s := range []string{"0", "-1", "12345", "-9223372036854775809", "18446744073709551616",
+		"-1267650600228229401496703205376", "1267650600228229401496703205377"} {
+		x, _ := new(big.Int).SetString(s, 10)
+		bs, err := testMarshal(x, h)
+		checkErrT(t, err)
+		var x2 *big.Int
+		checkErrT(t, testUnmarshal(&x2, bs, h))
+		checkBig(x2, x)
+	}
+
+	f, _ := new(big.Float).SetPrec(200).SetString("1.000000000000000000000000000000000001e+100")
+	v := TestBig{I: big.NewInt(-7), F: f, R: big.NewRat(1, 3)}
+	v.I.Lsh(v.I, 100)
+	v.I2.SetInt64(42)
+	bs, err := testMarshal(v, h)
+	checkErrT(t, err)
+	var v2 TestBig
+	checkErrT(t, testUnmarshal(&v2, bs, h))
+	checkBig(v2.I, v.I)
+	checkBig(&v2.I2, &v.I2)
+	checkBig(v2.R, v.R)
+	checkEqualT(t, v2.F.Text('g', -1), f.Text('g', -1))
+	checkEqualT(t, v2.NilI, (*big.Int)(nil))
+
+	if naked {
+		// decoding into a nil interface{} gives back the math/big types
+		bs, err = testMarshal([]interface{}{v.I, v.F, v.R}, h)
+		checkErrT(t, err)
+		var iv []interface{}
+		checkErrT(t, testUnmarshal(&iv, bs, h))
+		checkEqualT(t, len(iv), 3)
+		checkBig(iv[0], v.I)
+		checkBig(iv[1], v.F)
+		checkBig(iv[2], v.R)
+	}
+
+	// plain numbers decode into math/big values
+	bs, err = testMarshal([]interface{}{-5, 2.5}, h)
+	checkErrT(t, err)
+	var nums struct {
+		I *big.Int
+		F *big.Float
+	}
+	var rats []*big.Rat
+	checkErrT(t, testUnmarshal(&rats, bs, h))
+	checkBig(rats, []*big.Rat{big.NewRat(-5, 1), big.NewRat(5, 2)})
+	bs, err = testMarshal(map[string]interface{}{"I": -5, "F": 2.5}, h)
+	checkErrT(t, err)
+	checkErrT(t, testUnmarshal(&nums, bs, h))
+	checkBig(nums.I, big.NewInt(-5))
+	checkEqualT(t, nums.F.String(), "2.5")
+}
+
+func TestMsgpackBig(t *testing.T) {
+	h := &MsgpackHandle{WriteExt: true}
+	checkErrT(t, h.SetBigExtTag(30))
+	doTestBig(t, h, true)
+	h = &MsgpackHandle{}
+	checkErrT(t, h.SetBigExtTag(30))
+	doTestBig(t, h, false)
+
+	// the math/big tag cannot be the tag of a registered (or the timestamp) extension
+	if err := h.AddExt(byteSliceTyp, 30, h.BinaryEncodeExt, h.BinaryDecodeExt); err == nil {
+		logT(t, "Expecting error registering an extension with the math/big tag")
+		t.FailNow()
+	}
+	h = &MsgpackHandle{WriteExt: true}
+	checkErrT(t, h.AddExt(byteSliceTyp, 30, h.BinaryEncodeExt, h.BinaryDecodeExt))
+	for _, tag := range []byte{30, 0xff} {
+		if err := h.SetBigExtTag(tag); err == nil {
+			logT(t, "Expecting error setting the math/big tag to %v", tag)
+			t.FailNow()
+		}
+	}
+
+	// without a math/big tag, tag 0 is free for a registered extension (e.g. binary)
+	h = &MsgpackHandle{WriteExt: true}
+	checkErrT(t, h.AddExt(byteSliceTyp, 0, h.BinaryEncodeExt, h.BinaryDecodeExt))
+	bs, err := testMarshal([]byte{1, 2}, h)
+	checkErrT(t, err)
+	var v interface{}
+	checkErrT(t, testUnmarshal(&v, bs, h))
+	checkEqualT(t, v, []byte{1, 2})
+}
+
+func TestBincBig(t *testing.T) {
+	doTestBig(t, testBincH, true)
+
+	// decimals (including infinities) decode into floats
+	for _, x := range []*big.Float{new(big.Float).SetInf(false), new(big.Float).SetInf(true),
+		big.NewFloat(2.5), new(big.Float).SetRat(big.NewRat(1, 4))} {
+		bs, err := testMarshal(x, testBincH)
+		checkErrT(t, err)
+		var f float64
+		checkErrT(t, testUnmarshal(&f, bs, testBincH))
+		f2, _ := x.Float64()
+		checkEqualT(t, f, f2)
+	}
+	bs, err := testMarshal(big.NewRat(1, 4), testBincH)
+	checkErrT(t, err)
+	var f float64
+	checkErrT(t, testUnmarshal(&f, bs, testBincH))
+	checkEqualT(t, f, 0.25)
+}
+
+func doTestDecodeLimits(t *testing.T, h Handle, o *DecodeOptions) {
+	checkLimit := func(v interface{}, bs []byte, limit string) {
+		err := testUnmarshal(v, bs, h)
+		*o = DecodeOptions{}
+		if derr, ok := err.(*DecodeError); ok {
+			if lerr, ok := derr.Err.(*LimitError); ok && lerr.Limit == limit {
+				logT(t, "Got expected error: %v", err)
+				return
+			}
+		}
+		logT(t, "Expecting a LimitError for %s. Got: %v", limit, err)
+		t.FailNow()
+	}
+	s := "0123456789"
+	ints := []int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}
+	nested := []interface{}{[]interface{}{[]interface{}{1}}}
+	sbs, err := testMarshal(s, h)
+	checkErrT(t, err)
+	ibs, err := testMarshal(ints, h)
+	checkErrT(t, err)
+	nbs, err := testMarshal(nested, h)
+	checkErrT(t, err)
+	// within the limits
+	*o = DecodeOptions{MaxContainerLen: 10, MaxBytesLen: 10, MaxDepth: 3, MaxTotalBytes: int64(len(ibs))}
+	var s2 string
+	var ints2 []int
+	var nested2 interface{}
+	checkErrT(t, testUnmarshal(&s2, sbs, h))
+	checkErrT(t, testUnmarshal(&ints2, ibs, h))
+	checkErrT(t, testUnmarshal(&nested2, nbs, h))
+	checkEqualT(t, ints2, ints)
+
+	o.MaxBytesLen = 9
+	checkLimit(&s2, sbs, "MaxBytesLen")
+	o.MaxContainerLen = 9
+	checkLimit(&ints2, ibs, "MaxContainerLen")
+	o.MaxContainerLen = 9
+	checkLimit(&nested2, ibs, "MaxContainerLen")
+	o.MaxDepth = 2
+	checkLimit(&nested2, nbs, "MaxDepth")
+	o.MaxTotalBytes = int64(len(ibs) - 1)
+	checkLimit(&ints2, ibs, "MaxTotalBytes")
+	// extra values are skipped when decoding into a struct, but the limits still apply
+	bs, err := testMarshal([]interface{}{"a", "b", "c", ints}, h)
+	checkErrT(t, err)
+	var v TestABC
+	checkErrT(t, testUnmarshal(&v, bs, h))
+	checkEqualT(t, v, TestABC{"a", "b", "c"})
+	o.MaxContainerLen = 9
+	checkLimit(&v, bs, "MaxContainerLen")
+	// so do skipped and Raw values
+	o.MaxBytesLen = 9
+	var r Raw
+	checkLimit(&r, sbs, "MaxBytesLen")
+	bs, err = testMarshal(map[string]interface{}{"A": "a", "X": s}, h)
+	checkErrT(t, err)
+	o.MaxBytesLen = 9
+	checkLimit(&v, bs, "MaxBytesLen")
+}
+
+func TestMsgpackDecodeLimits(t *testing.T) {
+	h := new(MsgpackHandle)
+	doTestDecodeLimits(t, h, &h.DecodeOptions)
+	// a small stream claiming to contain a huge array or string
+	h.MaxContainerLen = 1 << 16
+	var v interface{}
+	err := testUnmarshal(&v, []byte{0xdd, 0xff, 0xff, 0xff, 0xff}, h)
+	if derr, ok := err.(*DecodeError); !ok {
+		logT(t, "Expecting a DecodeError. Got: %v", err)
+		t.FailNow()
+	} else if _, ok = derr.Err.(*LimitError); !ok {
+		logT(t, "Expecting a LimitError. Got: %v", err)
+		t.FailNow()
+	}
+	// a small stream claiming to contain a huge string, read into a Raw from an io.Reader
+	h.MaxBytesLen = 16
+	var r Raw
+	err = NewDecoder(bytes.NewReader([]byte{0xdb, 0x04, 0, 0, 0}), h).Decode(&r)
+	if derr, ok := err.(*DecodeError); !ok {
+		logT(t, "Expecting a DecodeError. Got: %v", err)
+		t.FailNow()
+	} else if _, ok = derr.Err.(*LimitError); !ok {
+		logT(t, "Expecting a LimitError. Got: %v", err)
+		t.FailNow()
+	}
+}
+
+func TestBincDecodeLimits(t *testing.T) {
+	h := new(BincHandle)
+	doTestDecodeLimits(t, h, &h.DecodeOptions)
+}
+
 func TestMsgpackCodecsTable(t *testing.T) {
 	testCodecTableOne(t, testMsgpackH)
 }
@@ -901,6 +2223,127 @@ func TestMsgpackCodecsMisc(t *testing.T) {
 	testCodecMiscOne(t, testMsgpackH)
 }
 
+func TestMsgpackTimestampExt(t *testing.T) {
+	h := &MsgpackHandle{WriteExt: true}
+	for _, x := range []struct {
+		t  time.Time
+		bs []byte
+	}{
+		{time.Unix(1, 0).UTC(), []byte{0xd6, 0xff, 0, 0, 0, 1}},
+		{time.Unix(1, 5).UTC(), []byte{0xd7, 0xff, 0, 0, 0, 0x14, 0, 0, 0, 1}},
+		{time.Unix(-1, 0).UTC(), []byte{0xc7, 12, 0xff, 0, 0, 0, 0, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}},
+	} {
+		bs, err := testMarshal(x.t, h)
+		checkErrT(t, err)
+		checkEqualT(t, bs, x.bs)
+		var tt time.Time
+		checkErrT(t, testUnmarshal(&tt, bs, h))
+		checkEqualT(t, tt, x.t)
+		// nil interface{} decodes to a time.Time, even if a legacy ext is registered
+		var v interface{}
+		checkErrT(t, testUnmarshal(&v, bs, testMsgpackH))
+		checkEqualT(t, v, x.t)
+	}
+
+	// without WriteExt, the timestamp is written as raw bytes
+	h.WriteExt = false
+	tt0 := time.Date(2012, 2, 2, 2, 2, 2, 2000, time.UTC)
+	bs, err := testMarshal(tt0, h)
+	checkErrT(t, err)
+	checkEqualT(t, bs[0], byte(0xa8))
+	var tt time.Time
+	checkErrT(t, testUnmarshal(&tt, bs, h))
+	checkEqualT(t, tt, tt0)
+
+	// nil and non-nil pointers to time.Time
+	type ts struct {
+		T1, T2 *time.Time
+	}
+	bs, err = testMarshal(ts{T2: &tt0}, h)
+	checkErrT(t, err)
+	var ts2 ts
+	checkErrT(t, testUnmarshal(&ts2, bs, h))
+	if ts2.T1 != nil || ts2.T2 == nil || !ts2.T2.Equal(tt0) {
+		logT(t, "Not Equal: %v, %v", ts2.T1, ts2.T2)
+		failT(t)
+	}
+
+	// a registered extension (legacy layout) takes precedence over the builtin
+	hl := &MsgpackHandle{WriteExt: true}
+	checkErrT(t, hl.AddExt(timeTyp, 1, hl.TimeEncodeExt, hl.TimeDecodeExt))
+	bs, err = testMarshal(timeToCompare1, hl)
+	checkErrT(t, err)
+	checkEqualT(t, bs[:3], []byte{0xc7, byte(len(encodeTime(timeToCompare1))), 1})
+	checkErrT(t, testUnmarshal(&tt, bs, hl))
+	checkEqualT(t, tt, timeToCompare1)
+}
+
+func TestMsgpackBinaryExt(t *testing.T) {
+	type testBlob []byte
+	h := &MsgpackHandle{WriteExt: true}
+	checkErrT(t, h.AddExt(byteSliceTyp, 0, h.BinaryEncodeExt, h.BinaryDecodeExt))
+	checkErrT(t, h.AddExt(reflect.TypeOf(testBlob(nil)), 2, h.BinaryEncodeExt, h.BinaryDecodeExt))
+
+	bs, err := testMarshal([]byte{1, 2}, h)
+	checkErrT(t, err)
+	checkEqualT(t, bs, []byte{0xd5, 0, 1, 2})
+	bs, err = testMarshal(testBlob{1, 2, 3, 4}, h)
+	checkErrT(t, err)
+	checkEqualT(t, bs, []byte{0xd6, 2, 1, 2, 3, 4})
+
+	// the ext tag tells binary apart from strings when decoding into a nil interface{}
+	var v interface{}
+	checkErrT(t, testUnmarshal(&v, bs, h))
+	checkEqualT(t, v, testBlob{1, 2, 3, 4})
+	bs, err = testMarshal([]interface{}{"abc", []byte("abc"), []byte{}}, h)
+	checkErrT(t, err)
+	h.RawToString = true
+	v = nil
+	checkErrT(t, testUnmarshal(&v, bs, h))
+	checkEqualT(t, v, []interface{}{"abc", []byte("abc"), []byte{}})
+
+	// decoded bytes do not share memory with the input
+	bs, err = testMarshal([]byte{1, 2}, h)
+	checkErrT(t, err)
+	var b2 []byte
+	checkErrT(t, testUnmarshal(&b2, bs, h))
+	bs[2] = 9
+	checkEqualT(t, b2, []byte{1, 2})
+}
+
+func TestExtRegistration(t *testing.T) {
+	type testBlob []byte
+	blobTyp := reflect.TypeOf(testBlob(nil))
+	h := &MsgpackHandle{WriteExt: true}
+	checkErrT(t, h.AddExt(byteSliceTyp, 0, h.BinaryEncodeExt, h.BinaryDecodeExt))
+	if err := h.AddExt(blobTyp, 0, h.BinaryEncodeExt, h.BinaryDecodeExt); err == nil {
+		logT(t, "Expecting error registering an already registered tag for another type")
+		failT(t)
+	}
+	// re-registering a type under a new tag frees its old tag
+	checkErrT(t, h.AddExt(byteSliceTyp, 1, h.BinaryEncodeExt, h.BinaryDecodeExt))
+	checkErrT(t, h.AddExt(blobTyp, 0, h.BinaryEncodeExt, h.BinaryDecodeExt))
+	checkEqualT(t, h.getExtForTag(0).rt, blobTyp)
+	checkEqualT(t, h.getExtForTag(1).rt, byteSliceTyp)
+
+	bs, err := testMarshal(testBlob{1, 2}, h)
+	checkErrT(t, err)
+	var v interface{}
+	checkErrT(t, testUnmarshal(&v, bs, h))
+	checkEqualT(t, v, testBlob{1, 2})
+
+	h.RemoveExt(blobTyp)
+	if h.getExt(reflect.ValueOf(blobTyp).Pointer()) != nil || h.getExtForTag(0) != nil {
+		logT(t, "Expecting extension for tag 0 to be removed")
+		failT(t)
+	}
+	v = nil
+	checkErrT(t, testUnmarshal(&v, bs, h))
+	checkEqualT(t, v, RawExt{0, []byte{1, 2}})
+	checkErrT(t, h.AddExt(byteSliceTyp, 0, nil, nil))
+	checkErrT(t, h.AddExt(blobTyp, 1, h.BinaryEncodeExt, h.BinaryDecodeExt))
+}
+
 func TestBincCodecsTable(t *testing.T) {
 	testCodecTableOne(t, testBincH)
 }
@@ -921,6 +2364,178 @@ func TestBincRpcGo(t *testing.T) {
 	doTestRpcOne(t, GoRpc, testBincH, true, 0)
 }
 
+func TestCborCodecsTable(t *testing.T) {
+	testCodecTableOne(t, testCborH)
+}
+
+func TestCborCodecsMisc(t *testing.T) {
+	testCodecMiscOne(t, testCborH)
+}
+
+func TestCborRpcGo(t *testing.T) {
+	doTestRpcOne(t, GoRpc, testCborH, true, 0)
+}
+
+func TestCborSpecExamples(t *testing.T) {
+	// encoding examples from Appendix A of RFC 7049
+	for _, x := range []struct {
+		v  interface{}
+		bs []byte
+	}{
+		{uint(0), []byte{0x00}},
+		{uint8(23), []byte{0x17}},
+		{24, []byte{0x18, 0x18}},
+		{1000, []byte{0x19, 0x03, 0xe8}},
+		{uint64(18446744073709551615), []byte{0x1b, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}},
+		{-1, []byte{0x20}},
+		{-1000, []byte{0x39, 0x03, 0xe7}},
+		{true, []byte{0xf5}},
+		{nil, []byte{0xf6}},
+		{"a", []byte{0x61, 0x61}},
+		{[]byte{1, 2, 3, 4}, []byte{0x44, 1, 2, 3, 4}},
+		{[]int{1, 2, 3}, []byte{0x83, 0x01, 0x02, 0x03}},
+		{map[string]bool{"a": true}, []byte{0xa1, 0x61, 0x61, 0xf5}},
+	} {
+		bs, err := testMarshal(x.v, testCborH)
+		checkErrT(t, err)
+		checkEqualT(t, bs, x.bs)
+	}
+
+	// decoding examples, including half-floats, tags and indefinite lengths
+	for _, x := range []struct {
+		bs []byte
+		v  interface{}
+	}{
+		{[]byte{0xf9, 0x3c, 0x00}, 1.0},
+		{[]byte{0xf9, 0x7b, 0xff}, 65504.0},
+		{[]byte{0xf9, 0xc4, 0x00}, -4.0},
+		{[]byte{0x3b, 0x7f, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}, int64(math.MinInt64)},
+		{[]byte{0xc1, 0x1a, 0x51, 0x4b, 0x67, 0xb0}, time.Date(2013, 3, 21, 20, 4, 0, 0, time.UTC)},
+		// unregistered tag 32 (URI) on a text string: value is decoded as if untagged
+		{[]byte{0xd8, 0x20, 0x61, 0x61}, "a"},
+		// unregistered tag 2 (bignum) on a byte string: value is kept as a RawExt
+		{[]byte{0xc2, 0x49, 0x01, 0, 0, 0, 0, 0, 0, 0, 0}, RawExt{2, []byte{1, 0, 0, 0, 0, 0, 0, 0, 0}}},
+		{[]byte{0x5f, 0x42, 0x01, 0x02, 0x43, 0x03, 0x04, 0x05, 0xff}, []byte{1, 2, 3, 4, 5}},
+		{[]byte{0x7f, 0x65, 0x73, 0x74, 0x72, 0x65, 0x61, 0x64, 0x6d, 0x69, 0x6e, 0x67, 0xff}, "streaming"},
+		{[]byte{0x9f, 0x01, 0x82, 0x02, 0x03, 0x9f, 0x04, 0x05, 0xff, 0xff},
+			[]interface{}{uint64(1), []interface{}{uint64(2), uint64(3)}, []interface{}{uint64(4), uint64(5)}}},
+		{[]byte{0xbf, 0x63, 0x46, 0x75, 0x6e, 0xf5, 0x63, 0x41, 0x6d, 0x74, 0x21, 0xff},
+			map[interface{}]interface{}{"Fun": true, "Amt": int64(-2)}},
+	} {
+		var v interface{}
+		checkErrT(t, testUnmarshal(&v, x.bs, testCborH))
+		checkEqualT(t, v, x.v)
+	}
+
+	// indefinite-length containers into typed values
+	type cborT struct {
+		Fun bool
+		Amt int
+	}
+	var ts cborT
+	checkErrT(t, testUnmarshal(&ts, []byte{0xbf, 0x63, 0x46, 0x75, 0x6e, 0xf5, 0x63, 0x41, 0x6d, 0x74, 0x21, 0xff}, testCborH))
+	checkEqualT(t, ts, cborT{true, -2})
+	var is []int
+	checkErrT(t, testUnmarshal(&is, []byte{0x9f, 0x01, 0x02, 0x03, 0x04, 0x05, 0xff}, testCborH))
+	checkEqualT(t, is, []int{1, 2, 3, 4, 5})
+
+	// a long run of tags is skipped without growing the stack
+	var vt interface{}
+	bs := append(bytes.Repeat([]byte{0xd8, 0x64}, 1<<20), 0x01)
+	checkErrT(t, testUnmarshal(&vt, bs, testCborH))
+	checkEqualT(t, vt, uint64(1))
+
+	// an extension registered with tag 0 takes precedence over time.Time
+	type testBlob []byte
+	h := &CborHandle{}
+	mh := &MsgpackHandle{}
+	checkErrT(t, h.AddExt(reflect.TypeOf(testBlob(nil)), 0, mh.BinaryEncodeExt, mh.BinaryDecodeExt))
+	bs, err := testMarshal(testBlob{1, 2}, h)
+	checkErrT(t, err)
+	checkEqualT(t, bs, []byte{0xc0, 0x42, 1, 2})
+	var v interface{}
+	checkErrT(t, testUnmarshal(&v, bs, h))
+	checkEqualT(t, v, testBlob{1, 2})
+}
+
+func TestCborRawExt(t *testing.T) {
+	doTestRawExt(t, testCborH)
+}
+
+func TestJsonCodecsMisc(t *testing.T) {
+	testCodecMiscOne(t, testJsonH)
+}
+
+func TestJsonEncodeDecode(t *testing.T) {
+	type jsonT struct {
+		S  string   `codec:"s"`
+		F  float64  `codec:"f"`
+		Bs []byte   `codec:"bs"`
+		N  *int     `codec:"n"`
+		E  string   `codec:",omitempty"`
+		Mi map[int]string
+	}
+	v := jsonT{S: "a\"b\\c\n\u2028", F: 2, Bs: []byte("hello"), Mi: map[int]string{-5: "x"}}
+	bs, err := testMarshal(v, testJsonH)
+	checkErrT(t, err)
+	checkEqualT(t, string(bs),
+		`{"Mi":{"-5":"x"},"bs":"aGVsbG8=","f":2.0,"n":null,"s":"a\"b\\c\n\u2028"}`)
+	var v2 jsonT
+	checkErrT(t, testUnmarshal(&v2, bs, testJsonH))
+	checkEqualT(t, v2, v)
+
+	// schema-less decoding uses map[string]interface{}, and int64/uint64/float64 for numbers.
+	var v3 interface{}
+	in := " {\"a\" : [1, -2, 3.5, 1e2, \"x\\u00e9\\ud834\\udd1e\", true, null], \"b\": {}, \"c\": []} "
+	checkErrT(t, testUnmarshal(&v3, []byte(in), testJsonH))
+	checkEqualT(t, v3, map[string]interface{}{
+		"a": []interface{}{uint64(1), int64(-2), 3.5, 100.0, "x\u00e9\U0001D11E", true, nil},
+		"b": map[string]interface{}{},
+		"c": []interface{}{},
+	})
+
+	// a lone surrogate is replaced by U+FFFD, and what follows it is kept
+	for _, x := range []struct{ in, out string }{
+		{`"\ud800"`, "\ufffd"},
+		{`"\ud800\n"`, "\ufffd\n"},
+		{`"\ud800\u0041"`, "\ufffdA"},
+		{`"\ud800\ud800\udc00x"`, "\ufffd\U00010000x"},
+		{`"\udc00b"`, "\ufffdb"},
+	} {
+		var s string
+		checkErrT(t, testUnmarshal(&s, []byte(x.in), testJsonH))
+		checkEqualT(t, s, x.out)
+	}
+
+	// a number at the end of the stream is terminated by EOF.
+	var i int
+	checkErrT(t, NewDecoder(bytes.NewBufferString("123"), testJsonH).Decode(&i))
+	checkEqualT(t, i, 123)
+
+	for _, in := range []string{`[1,2}`, `{"a":1]`, `[1,]`, `{"a" 1}`, `tru`, `"abc`} {
+		if err = testUnmarshal(&v3, []byte(in), testJsonH); err == nil {
+			logT(t, "Expecting error decoding invalid json: %s", in)
+			failT(t)
+		}
+	}
+	// numbers must follow the RFC 7159 grammar
+	for _, in := range []string{`01`, `1.`, `-`, `.5`, `+1`, `1e`, `1e+`, `-01`, `[01]`, `{"a":1.}`} {
+		var iv interface{}
+		if err = testUnmarshal(&iv, []byte(in), testJsonH); err == nil {
+			logT(t, "Expecting error decoding invalid json number: %s", in)
+			failT(t)
+		}
+	}
+}
+
+func TestJsonCodecsTable(t *testing.T) {
+	testCodecTableOne(t, testJsonH)
+}
+
+func TestJsonRpcGo(t *testing.T) {
+	doTestRpcOne(t, GoRpc, testJsonH, true, 0)
+}
+
 //TODO: 
 //  - Add test for decoding empty list/map in stream into a nil slice/map
 
@@ -4,7 +4,9 @@
 package codec
 
 import (
+	"fmt"
 	"io"
+	"io/ioutil"
 	"reflect"
 )
 
@@ -43,12 +45,51 @@ const (
 	detExt
 )
 
+// ValueType is the type of the next value in the stream, as returned by Decoder.NextType.
+type ValueType uint8
+
+const (
+	ValueTypeNil       = ValueType(detNil)
+	ValueTypeInt       = ValueType(detInt)
+	ValueTypeUint      = ValueType(detUint)
+	ValueTypeFloat     = ValueType(detFloat)
+	ValueTypeBool      = ValueType(detBool)
+	ValueTypeString    = ValueType(detString)
+	ValueTypeBytes     = ValueType(detBytes)
+	ValueTypeMap       = ValueType(detMap)
+	ValueTypeArray     = ValueType(detArray)
+	ValueTypeTimestamp = ValueType(detTimestamp)
+	ValueTypeExt       = ValueType(detExt)
+)
+
+var valueTypeNames = [...]string{"unset", "nil", "int", "uint", "float", "bool",
+	"string", "bytes", "map", "array", "timestamp", "ext"}
+
+func (vt ValueType) String() string {
+	if int(vt) < len(valueTypeNames) {
+		return valueTypeNames[vt]
+	}
+	return fmt.Sprintf("ValueType(%d)", uint8(vt))
+}
+
 // decReader abstracts the reading source, allowing implementations that can
 // read from an io.Reader or directly off a byte slice with zero-copying.
 type decReader interface {
 	readn(n int) []byte
 	readb([]byte)
 	readn1() uint8
+	// readn1eof is like readn1, but returns eof=true instead of panicing at end of stream.
+	readn1eof() (v uint8, eof bool)
+	// skip reads past the next n bytes, without returning them.
+	skip(n int)
+	// numread returns the number of bytes read so far.
+	numread() int64
+	// setLimit limits the number of bytes read from numread=start, to max (0 means no limit).
+	setLimit(start, max int64)
+	// track starts recording the bytes read. stopTrack stops, and returns them.
+	// The returned slice is only valid until the next read.
+	track()
+	stopTrack() []byte
 	readUint16() uint16
 	readUint32() uint32
 	readUint64() uint64
@@ -70,11 +111,40 @@ type decDriver interface {
 	// decodeString can also decode symbols
 	decodeString() (s string)
 	decodeBytes(bs []byte) (bsOut []byte, changed bool)
-	decodeExt(tag byte) []byte
+	// decodeExt returns the tag and payload of an extension, checking that
+	// the tag matches if verifyTag is set.
+	decodeExt(verifyTag bool, tag byte) (xtag byte, xbs []byte)
+	// readMapLen and readArrayLen return -1 if the length is not known up front
+	// (e.g. json). The end of the container is then found using checkBreak.
 	readMapLen() int
 	readArrayLen() int
+	// checkBreak returns true (and consumes the end marker) if the current
+	// container, whose length was not known up front, has no more elements.
+	checkBreak() bool
+	readArrayEntrySeparator()
+	readMapEntrySeparator()
+	readMapKVSeparator()
+	// swallow reads past the next value in the stream (including the contents
+	// of containers and extensions), without decoding it.
+	// Formats which give the number of values in a container (e.g. msgpack, binc)
+	// read values in a loop (swallow1 reads past one value, adding the number of values
+	// it contains to those left), so deeply nested values do not grow the stack.
+	swallow()
+	// decodeRaw returns the encoded bytes of the next value (see Raw).
+	decodeRaw() []byte
+	// reset prepares the driver for reading from a new stream.
+	reset(r decReader)
 }
 
+// decNoSeparator is embedded by decDrivers for formats where every container
+// is prefixed by its length and has no separators between entries (e.g. msgpack, binc).
+type decNoSeparator struct{}
+
+func (_ decNoSeparator) checkBreak() bool         { return false }
+func (_ decNoSeparator) readArrayEntrySeparator() {}
+func (_ decNoSeparator) readMapEntrySeparator()   {}
+func (_ decNoSeparator) readMapKVSeparator()      {}
+
 // decFnInfo has methods for registering handling decoding of a specific type
 // based on some characteristics (builtin, extension, reflect Kind, etc)
 type decFnInfo struct {
@@ -83,8 +153,8 @@ type decFnInfo struct {
 	dd  decDriver
 	rt    reflect.Type
 	rtid  uintptr
-	xfFn  func(reflect.Value, []byte) error
-	xfTag byte 
+	xf    *extTypeTagFn
+	xfIndir int8
 }
 
 type decFn struct {
@@ -98,29 +168,118 @@ type Decoder struct {
 	d decDriver
 	h decodeHandleI
 	f map[uintptr]decFn
+	o *DecodeOptions
+	p valuePath
+	// eofOK is set while reading the first byte of a value,
+	// where an io.EOF means the stream ended cleanly.
+	eofOK bool
+	depth int // current nesting depth of containers
+	// tok holds the arrays and maps being read using ReadArrayStart/ReadMapStart.
+	tok []tokContainer
+
+	// readers are kept here, so they can be reused on Reset
+	ri ioDecReader
+	rb bytesDecReader
 }
 
-func (f *decFnInfo) builtin(rv reflect.Value) {
-	baseRv := rv
-	baseIndir := f.sis.baseIndir
-	for j := int8(0); j < baseIndir; j++ {
-		baseRv = baseRv.Elem()
+// tokContainer is an array or map being read using ReadArrayStart/ReadMapStart,
+// or written using WriteArrayStart/WriteMapStart.
+type tokContainer struct {
+	n, j int  // the length (-1 if not known up front), and index of the next element
+	m    bool // it is a map
+	// rd is the number of values read or written of the current element 
+	// (when reading, -1 before More is first called).
+	// sep is set once the separator between the key and value of a map entry is read.
+	rd  int
+	sep bool
+}
+
+// size is the number of values in each element (2 for a map entry: the key and value).
+func (t *tokContainer) size() int {
+	if t.m {
+		return 2
 	}
-	f.dd.decodeBuiltinType(f.sis.baseId, baseRv)
+	return 1
+}
+
+// DecodeError is the error returned when decoding fails.
+//
+// It records where the error happened, in the stream and in the value being decoded into.
+// An io.EOF in the middle of a value is reported as an io.ErrUnexpectedEOF.
+// However, an io.EOF before any part of a value is read is returned as is (not as a DecodeError),
+// so callers can detect the end of the stream.
+type DecodeError struct {
+	Offset int64        // number of bytes read from the stream when the error happened
+	Path   string       // path to the value being decoded, e.g. Outer.Items[3].Name
+	Type   reflect.Type // type of the value being decoded (if known)
+	Err    error        // underlying error
+}
+
+func (e *DecodeError) Error() string {
+	if s := errContext(e.Path, e.Type); s != "" {
+		return fmt.Sprintf("%v (offset: %d, %s)", e.Err, e.Offset, s)
+	}
+	return fmt.Sprintf("%v (offset: %d)", e.Err, e.Offset)
+}
+
+// LimitError is the underlying error (in a DecodeError) when the stream
+// exceeds one of the limits configured in DecodeOptions.
+type LimitError struct {
+	Limit string // name of the DecodeOptions field, e.g. MaxContainerLen
+	Max   int64  // value of the limit
+	Value int64  // value found in the stream (e.g. the container length)
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("%s: %s exceeded. Limit: %d, Got: %d", msgTagDec, e.Limit, e.Max, e.Value)
+}
+
+func (f *decFnInfo) builtin(rv reflect.Value) {
+	f.dd.decodeBuiltinType(f.sis.baseId, f.baseRv(rv))
+}
+
+func (f *decFnInfo) raw(rv reflect.Value) {
+	rv.SetBytes(f.dd.decodeRaw())
+}
+
+func (f *decFnInfo) rawExt(rv reflect.Value) {
+	// the tag of rv is kept if the stream has none (e.g. json)
+	xtag, xbs := f.dd.decodeExt(false, rv.Interface().(RawExt).Tag)
+	rv.Set(reflect.ValueOf(RawExt{xtag, append(make([]byte, 0, len(xbs)), xbs...)}))
 }
 
 func (f *decFnInfo) ext(rv reflect.Value) {
-	xbs := f.dd.decodeExt(f.xfTag)
-	baseRv := rv
-	baseIndir := f.sis.baseIndir
-	for j := int8(0); j < baseIndir; j++ {
-		baseRv = baseRv.Elem()
+	if f.xf.ext != nil && !f.d.h.writeExt() {
+		// the intermediate value was encoded in place
+		var v interface{}
+		f.d.decode(&v)
+		if fnerr := f.xf.ext.UpdateExt(decIndirRv(rv, f.xfIndir).Addr().Interface(), v); fnerr != nil {
+			panic(fnerr)
+		}
+		return
 	}
-	if fnerr := f.xfFn(baseRv, xbs); fnerr != nil {
+	_, xbs := f.dd.decodeExt(true, f.xf.tag)
+	if fnerr := f.xf.decode(f.d.h.(Handle), decIndirRv(rv, f.xfIndir), xbs); fnerr != nil {
 		panic(fnerr)
 	}
 }
 
+// baseRv dereferences rv down to its base type, allocating any nil pointers on the way.
+func (f *decFnInfo) baseRv(rv reflect.Value) reflect.Value {
+	return decIndirRv(rv, f.sis.baseIndir)
+}
+
+// decIndirRv dereferences rv indir times, allocating any nil pointers on the way.
+func decIndirRv(rv reflect.Value, indir int8) reflect.Value {
+	for j := int8(0); j < indir; j++ {
+		if rv.IsNil() {
+			rv.Set(reflect.New(rv.Type().Elem()))
+		}
+		rv = rv.Elem()
+	}
+	return rv
+}
+
 func (f *decFnInfo) binaryMarshal(rv reflect.Value) {
 	var bm binaryUnmarshaler
 	if f.sis.unmIndir == -1 {
@@ -141,6 +300,26 @@ func (f *decFnInfo) binaryMarshal(rv reflect.Value) {
 	}
 }
 
+func (f *decFnInfo) textUnmarshal(rv reflect.Value) {
+	tm := indirIntf(f.baseRv(rv), f.sis.tunmIndir-f.sis.baseIndir).(textUnmarshaler)
+	if fnerr := tm.UnmarshalText([]byte(f.dd.decodeString())); fnerr != nil {
+		panic(fnerr)
+	}
+}
+
+func (f *decFnInfo) jsonUnmarshal(rv reflect.Value) {
+	jm := indirIntf(f.baseRv(rv), f.sis.junmIndir-f.sis.baseIndir).(jsonUnmarshaler)
+	var bs []byte
+	if f.d.h.nativeJson() {
+		bs = f.dd.decodeRaw()
+	} else {
+		bs = []byte(f.dd.decodeString())
+	}
+	if fnerr := jm.UnmarshalJSON(bs); fnerr != nil {
+		panic(fnerr)
+	}
+}
+
 func (f *decFnInfo) kErr(rv reflect.Value) {
 	decErr("Unhandled value for kind: %v: %s", rv.Kind(), msgBadDesc)
 }
@@ -212,61 +391,94 @@ func (f *decFnInfo) kInterface(rv reflect.Value) {
 	f.d.decodeValue(rv.Elem())
 }
 
+func (f *decFnInfo) kInterfaceRegistered(rv reflect.Value) {
+	f.d.decodeRegistered(rv, f.d.h.typeRegistry().forIntf(f.rtid))
+}
+
 func (f *decFnInfo) kStruct(rv reflect.Value) {
+	f.d.depthIncr()
 	if currEncodedType := f.dd.currentEncodedType(); currEncodedType == detMap {
 		containerLen := f.dd.readMapLen()
 		if containerLen == 0 {
+			f.d.depth--
 			return
 		}
 		sissis := f.sis.sis 
-		for j := 0; j < containerLen; j++ {
+		for j := 0; f.d.mapNext(j, containerLen); j++ {
 			// var rvkencname string
 			// ddecode(&rvkencname)
 			f.dd.initReadNext()
 			rvkencname := f.dd.decodeString()
+			f.dd.readMapKVSeparator()
 			// rvksi := sis.getForEncName(rvkencname)
-			if k := f.sis.indexForEncName(rvkencname); k > -1 {
+			k := f.sis.indexForEncName(rvkencname)
+			if k < 0 && f.d.o.CaseInsensitiveKeys {
+				k = f.sis.indexForEncNameFold(rvkencname)
+			}
+			if k > -1 {
 				sfik := sissis[k]
+				f.d.p.pushName(sfik.name)
 				if sfik.i != -1 {
 					f.d.decodeValue(rv.Field(int(sfik.i)))
 				} else {
 					f.d.decodeValue(rv.FieldByIndex(sfik.is))
 				}
+				f.d.p.pop()
 				// f.d.decodeValue(sis.field(k, rv))
+			} else if f.sis.unknown != nil {
+				f.unknownField(rv, rvkencname)
 			} else {
 				if f.d.h.errorIfNoField() {
 					decErr("No matching struct field found when decoding stream map with key: %v", rvkencname)
 				} else {
-					var nilintf0 interface{}
-					f.d.decodeValue(reflect.ValueOf(&nilintf0).Elem())
+					f.dd.swallow()
 				}
 			}
 		}
 	} else if currEncodedType == detArray {
 		containerLen := f.dd.readArrayLen()
 		if containerLen == 0 {
+			f.d.depth--
 			return
 		}
-		for j, si := range f.sis.sisp {
-			if j == containerLen {
-				break
-			}
-			if si.i != -1 {
-				f.d.decodeValue(rv.Field(int(si.i)))
+		sisp := f.sis.sisp
+		for j := 0; f.d.arrayNext(j, containerLen); j++ {
+			if j < len(sisp) {
+				si := sisp[j]
+				f.d.p.pushName(si.name)
+				if si.i != -1 {
+					f.d.decodeValue(rv.Field(int(si.i)))
+				} else {
+					f.d.decodeValue(rv.FieldByIndex(si.is))
+				}
+				f.d.p.pop()
 			} else {
-				f.d.decodeValue(rv.FieldByIndex(si.is))
-			}
-		}
-		if containerLen > len(f.sis.sisp) {
-			// read remaining values and throw away
-			for j := len(f.sis.sisp); j < containerLen; j++ {
-				var nilintf0 interface{}
-				f.d.decodeValue(reflect.ValueOf(&nilintf0).Elem())
+				// read remaining values and throw away
+				f.dd.swallow()
 			}
 		}
 	} else {
 		decErr("Only encoded map or array can be decoded into a struct. (decodeEncodedType: %x)", currEncodedType)
 	}
+	f.d.depth--
+}
+
+// unknownField decodes the value of a key which matched no field of struct rv,
+// into the map field with the unknown tag option.
+func (f *decFnInfo) unknownField(rv reflect.Value, k string) {
+	si := f.sis.unknown
+	rvm := si.field(rv)
+	if rvm.IsNil() {
+		rvm.Set(reflect.MakeMap(rvm.Type()))
+	}
+	rvk := reflect.ValueOf(k).Convert(rvm.Type().Key())
+	rvv := reflect.New(rvm.Type().Elem()).Elem()
+	f.d.p.pushName(si.name)
+	f.d.p.pushKey(rvk)
+	f.d.decodeValue(rvv)
+	f.d.p.pop()
+	f.d.p.pop()
+	rvm.SetMapIndex(rvk, rvv)
 }
 
 func (f *decFnInfo) kSlice(rv reflect.Value) {
@@ -281,12 +493,19 @@ func (f *decFnInfo) kSlice(rv reflect.Value) {
 		return
 	}
 
+	f.d.depthIncr()
 	containerLen := f.dd.readArrayLen()
+	if containerLen < 0 {
+		f.kSliceUnknownLen(rv)
+		f.d.depth--
+		return
+	}
 
 	if rv.IsNil() {
 		rv.Set(reflect.MakeSlice(f.rt, containerLen, containerLen))
 	} 
 	if containerLen == 0 {
+		f.d.depth--
 		return
 	}
 
@@ -305,9 +524,46 @@ func (f *decFnInfo) kSlice(rv reflect.Value) {
 	} else if containerLen > rvlen {
 		rv.SetLen(containerLen)
 	}
+	f.d.p.pushIndex(0)
 	for j := 0; j < containerLen; j++ {
+		if j > 0 {
+			f.dd.readArrayEntrySeparator()
+		}
+		f.d.p.setIndex(j)
+		f.d.decodeValue(rv.Index(j))
+	}
+	f.d.p.pop()
+	f.d.depth--
+}
+
+// kSliceUnknownLen decodes an array whose length is not known up front,
+// growing the slice as elements are read.
+func (f *decFnInfo) kSliceUnknownLen(rv reflect.Value) {
+	if rv.IsNil() {
+		rv.Set(reflect.MakeSlice(f.rt, 0, 0))
+	}
+	f.d.p.pushIndex(0)
+	for j := 0; f.d.arrayNext(j, -1); j++ {
+		f.d.p.setIndex(j)
+		if rvlen := rv.Len(); j >= rvlen {
+			if !rv.CanSet() {
+				decErr("Cannot reset slice with less len: %v than stream contents", rvlen)
+			}
+			if rvcap := rv.Cap(); j < rvcap {
+				rv.SetLen(j + 1)
+			} else {
+				rvcap = rvcap * 2
+				if rvcap < 4 {
+					rvcap = 4
+				}
+				rvn := reflect.MakeSlice(f.rt, j+1, rvcap)
+				reflect.Copy(rvn, rv)
+				rv.Set(rvn)
+			}
+		}
 		f.d.decodeValue(rv.Index(j))
 	}
+	f.d.p.pop()
 }
 
 func (f *decFnInfo) kArray(rv reflect.Value) {
@@ -315,6 +571,7 @@ func (f *decFnInfo) kArray(rv reflect.Value) {
 }
 
 func (f *decFnInfo) kMap(rv reflect.Value) {
+	f.d.depthIncr()
 	containerLen := f.dd.readMapLen()
 
 	if rv.IsNil() {
@@ -322,13 +579,15 @@ func (f *decFnInfo) kMap(rv reflect.Value) {
 	}
 	
 	if containerLen == 0 {
+		f.d.depth--
 		return
 	}
 
 	ktype, vtype := f.rt.Key(), f.rt.Elem()
-	for j := 0; j < containerLen; j++ {
+	for j := 0; f.d.mapNext(j, containerLen); j++ {
 		rvk := reflect.New(ktype).Elem()
 		f.d.decodeValue(rvk)
+		f.dd.readMapKVSeparator()
 
 		if ktype == intfTyp {
 			rvk = rvk.Elem()
@@ -341,9 +600,12 @@ func (f *decFnInfo) kMap(rv reflect.Value) {
 			rvv = reflect.New(vtype).Elem()
 		}
 
+		f.d.p.pushKey(rvk)
 		f.d.decodeValue(rvv)
+		f.d.p.pop()
 		rv.SetMapIndex(rvk, rvv)
 	}
+	f.d.depth--
 }
 
 // ioDecReader is a decReader that reads off an io.Reader
@@ -351,6 +613,10 @@ type ioDecReader struct {
 	r io.Reader
 	br io.ByteReader
 	x [8]byte //temp byte array re-used internally for efficiency
+	n int64   // num read
+	tr []byte // bytes read while tracking
+	trb bool  // tracking
+	readLimit
 }
 
 // bytesDecReader is a decReader that reads off a byte slice with zero copying
@@ -358,11 +624,41 @@ type bytesDecReader struct {
 	b []byte // data
 	c int    // cursor
 	a int    // available
+	t int    // cursor when tracking started
+	readLimit
+}
+
+// readLimit is embedded by decReaders to enforce DecodeOptions.MaxTotalBytes.
+type readLimit struct {
+	start, max int64
+}
+
+func (l *readLimit) setLimit(start, max int64) {
+	l.start, l.max = start, max
+}
+
+// checkLimit panics if reading n more bytes (after numread bytes) exceeds the limit.
+func (l *readLimit) checkLimit(numread int64, n int) {
+	if l.max > 0 && numread+int64(n)-l.start > l.max {
+		panic(&LimitError{"MaxTotalBytes", l.max, numread + int64(n) - l.start})
+	}
+}
+
+// atLimit reports whether no more bytes can be read (after numread bytes).
+// readn1eof treats it like the end of the stream.
+func (l *readLimit) atLimit(numread int64) bool {
+	return l.max > 0 && numread-l.start >= l.max
 }
 
 type decodeHandleI interface {
-	getDecodeExt(rt uintptr) (tag byte, fn func(reflect.Value, []byte) error)
+	getTypeInfo(rtid uintptr, rt reflect.Type) *typeInfo
+	typeRegistry() *typeRegistry
+	useJsonMarshaler() bool
+	getExtForType(rt reflect.Type, intf bool) (x *extTypeTagFn, indir int8)
+	writeExt() bool
+	nativeJson() bool
 	errorIfNoField() bool
+	decodeOptions() *DecodeOptions
 }
 
 type DecodeOptions struct {
@@ -375,32 +671,102 @@ type DecodeOptions struct {
 	// ErrorIfNoField controls whether an error is returned when decoding a map
 	// from a codec stream into a struct, and no matching struct field is found.
 	ErrorIfNoField bool
+	// CaseInsensitiveKeys controls whether a key in a stream map matches the name of
+	// a struct field ignoring case, when decoding into a struct (an exact match is preferred).
+	CaseInsensitiveKeys bool
+
+	// The limits below protect against untrusted input, e.g. a small stream
+	// claiming to contain a huge array. A limit of 0 means no limit.
+	// When a limit is exceeded, Decode returns a DecodeError wrapping a LimitError.
+
+	// MaxContainerLen is the maximum number of elements in an array or map.
+	MaxContainerLen int
+	// MaxBytesLen is the maximum length of a string, byte array or extension.
+	MaxBytesLen int
+	// MaxDepth is the maximum nesting depth of arrays and maps.
+	MaxDepth int
+	// MaxTotalBytes is the maximum number of bytes read in each call to Decode.
+	MaxTotalBytes int64
 }
 
 func (o *DecodeOptions) errorIfNoField() bool {
 	return o.ErrorIfNoField
 }
 
+func (o *DecodeOptions) decodeOptions() *DecodeOptions {
+	return o
+}
+
+func (o *DecodeOptions) checkContainerLen(n int) {
+	if o.MaxContainerLen > 0 && n > o.MaxContainerLen {
+		panic(&LimitError{"MaxContainerLen", int64(o.MaxContainerLen), int64(n)})
+	}
+}
+
+func (o *DecodeOptions) checkBytesLen(n int) {
+	if o.MaxBytesLen > 0 && n > o.MaxBytesLen {
+		panic(&LimitError{"MaxBytesLen", int64(o.MaxBytesLen), int64(n)})
+	}
+}
+
+func (o *DecodeOptions) checkDepth(depth int) {
+	if o.MaxDepth > 0 && depth > o.MaxDepth {
+		panic(&LimitError{"MaxDepth", int64(o.MaxDepth), int64(depth)})
+	}
+}
+
 // NewDecoder returns a Decoder for decoding a stream of bytes from an io.Reader.
 // 
 // For efficiency, Users are encouraged to pass in a memory buffered writer
 // (eg bufio.Reader, bytes.Buffer). 
 func NewDecoder(r io.Reader, h Handle) *Decoder {
-	z := ioDecReader{
-		r: r,
-	}
-	z.br, _ = r.(io.ByteReader)
-	return &Decoder{r: &z, d: h.newDecDriver(&z), h: h}
+	d := &Decoder{h: h, o: h.decodeOptions()}
+	d.resetIo(r)
+	d.d = h.newDecDriver(d.r)
+	return d
 }
 
 // NewDecoderBytes returns a Decoder which efficiently decodes directly
 // from a byte slice with zero copying.
 func NewDecoderBytes(in []byte, h Handle) *Decoder {
-	z := bytesDecReader{
+	d := &Decoder{h: h, o: h.decodeOptions()}
+	d.resetBytes(in)
+	d.d = h.newDecDriver(d.r)
+	return d
+}
+
+// Reset resets the Decoder to read from a new io.Reader.
+//
+// The Decoder keeps the functions it has cached for the types it has seen,
+// making it cheaper than creating a new Decoder (e.g. when pooled using a sync.Pool).
+// Symbols (for formats which support them e.g. binc) are not kept.
+func (d *Decoder) Reset(r io.Reader) {
+	d.resetIo(r)
+	d.d.reset(d.r)
+	d.tok = d.tok[:0]
+}
+
+// ResetBytes resets the Decoder to read from a new byte slice (see NewDecoderBytes).
+func (d *Decoder) ResetBytes(in []byte) {
+	d.resetBytes(in)
+	d.d.reset(d.r)
+	d.tok = d.tok[:0]
+}
+
+func (d *Decoder) resetIo(r io.Reader) {
+	d.ri = ioDecReader{
+		r: r,
+	}
+	d.ri.br, _ = r.(io.ByteReader)
+	d.r = &d.ri
+}
+
+func (d *Decoder) resetBytes(in []byte) {
+	d.rb = bytesDecReader{
 		b: in,
 		a: len(in),
 	}
-	return &Decoder{r: &z, d: h.newDecDriver(&z), h: h}
+	d.r = &d.rb
 }
 
 // Decode decodes the stream from reader and stores the result in the
@@ -430,6 +796,7 @@ func NewDecoderBytes(in []byte, h Handle) *Decoder {
 // type of the value. When a value is seen:
 //   - If an extension is registered for it, call that extension function
 //   - If it implements BinaryUnmarshaler, call its UnmarshalBinary(data []byte) error
+//   - If it implements TextUnmarshaler, call its UnmarshalText(text []byte) error
 //   - Else decode it based on its reflect.Kind
 // 
 // There are some special rules when decoding into containers (slice/array/map/struct).
@@ -442,12 +809,181 @@ func NewDecoderBytes(in []byte, h Handle) *Decoder {
 //     the container to its "zero" value (e.g. nil for slice/map).
 //   - Note that a struct can be decoded from an array in the stream,
 //     by updating fields as they occur in the struct.
+// 
+// Decode can also be used to decode the elements of an array or map
+// being read using ReadArrayStart or ReadMapStart.
 func (d *Decoder) Decode(v interface{}) (err error) {
+	defer d.wrapErr(&err)
 	defer panicToErr(&err)
+	if rv, ok := v.(reflect.Value); ok && rv.IsValid() {
+		d.p.reset(rv.Type())
+	} else {
+		d.p.reset(reflect.TypeOf(v))
+	}
+	d.tokBefore()
+	d.start()
 	d.decode(v)
+	d.tokAfter()
+	return
+}
+
+// Skip reads past the next value in the stream (including the contents of
+// containers and extensions), without decoding it.
+func (d *Decoder) Skip() (err error) {
+	defer d.wrapErr(&err)
+	defer panicToErr(&err)
+	d.p.reset(nil)
+	d.tokBefore()
+	d.start()
+	d.d.swallow()
+	d.tokAfter()
 	return
 }
 
+// NextType returns the type of the next value in the stream, without reading past it.
+// 
+// Together with ReadArrayStart, ReadMapStart, More, Decode and Skip, it allows
+// reading a stream one value at a time, without decoding a whole container. E.g.
+//   n, err := dec.ReadArrayStart()
+//   for more, err := dec.More(); more && err == nil; more, err = dec.More() {
+//       var row Row
+//       err = dec.Decode(&row)
+//   }
+func (d *Decoder) NextType() (vt ValueType, err error) {
+	defer d.wrapErr(&err)
+	defer panicToErr(&err)
+	d.p.reset(nil)
+	d.tokBefore()
+	d.start()
+	vt = ValueType(d.d.currentEncodedType())
+	return
+}
+
+// ReadArrayStart reads the start of an array, and returns its length 
+// (or -1 if it is not known up front e.g. json). 
+// 
+// More must then be called before reading each element of the array.
+func (d *Decoder) ReadArrayStart() (containerLen int, err error) {
+	return d.readContainerStart(false)
+}
+
+// ReadMapStart reads the start of a map, and returns its length 
+// (or -1 if it is not known up front e.g. json). 
+// 
+// More must then be called before reading each entry (key and value) of the map.
+func (d *Decoder) ReadMapStart() (containerLen int, err error) {
+	return d.readContainerStart(true)
+}
+
+func (d *Decoder) readContainerStart(m bool) (containerLen int, err error) {
+	defer d.wrapErr(&err)
+	defer panicToErr(&err)
+	d.p.reset(nil)
+	d.tokBefore()
+	d.start()
+	det := detArray
+	if m {
+		det = detMap
+	}
+	if vt := d.d.currentEncodedType(); vt != det {
+		decErr("Expecting %v in stream. Got: %v", ValueType(det), ValueType(vt))
+	}
+	d.depthIncr()
+	if m {
+		containerLen = d.d.readMapLen()
+	} else {
+		containerLen = d.d.readArrayLen()
+	}
+	d.tokAfter()
+	d.tok = append(d.tok, tokContainer{n: containerLen, m: m, rd: -1})
+	return
+}
+
+// More reports whether the array or map being read (last started using
+// ReadArrayStart or ReadMapStart) has another element, and prepares to read it.
+// 
+// Once More returns false, the end of the container has been read.
+// Any part of the previous element which was not read is skipped.
+func (d *Decoder) More() (more bool, err error) {
+	defer d.wrapErr(&err)
+	defer panicToErr(&err)
+	d.p.reset(nil)
+	n := len(d.tok)
+	if n == 0 {
+		decErr("More: No array or map is being read")
+	}
+	t := &d.tok[n-1]
+	for t.rd >= 0 && t.rd < t.size() {
+		d.tokBefore()
+		d.d.swallow()
+		t.rd++
+	}
+	d.depth = n
+	if t.m {
+		more = d.mapNext(t.j, t.n)
+	} else {
+		more = d.arrayNext(t.j, t.n)
+	}
+	if more {
+		t.j++
+		t.rd, t.sep = 0, false
+	} else {
+		d.tok = d.tok[:n-1]
+	}
+	return
+}
+
+// tokBefore is called before reading a value, to check that More was called
+// before reading an element of the container being read (if any), 
+// and to read the separator between the key and value of a map entry.
+func (d *Decoder) tokBefore() {
+	n := len(d.tok)
+	if n == 0 {
+		return
+	}
+	t := &d.tok[n-1]
+	if t.rd < 0 || t.rd >= t.size() {
+		decErr("More must be called before reading each element of an array or map")
+	}
+	if t.rd == 1 && !t.sep {
+		d.d.readMapKVSeparator()
+		t.sep = true
+	}
+}
+
+// tokAfter is called after reading a value.
+func (d *Decoder) tokAfter() {
+	if n := len(d.tok); n > 0 {
+		d.tok[n-1].rd++
+	}
+}
+
+// start reads the first byte of the next value,
+// noting that an io.EOF here is a clean end of the stream
+// (unless we are inside a container being read using ReadArrayStart/ReadMapStart).
+func (d *Decoder) start() {
+	d.depth = len(d.tok)
+	d.r.setLimit(d.r.numread(), d.o.MaxTotalBytes)
+	d.eofOK = len(d.tok) == 0
+	d.d.initReadNext()
+	d.eofOK = false
+}
+
+// wrapErr converts an error returned by a top-level decode function into a *DecodeError.
+func (d *Decoder) wrapErr(err *error) {
+	if *err == nil {
+		return
+	}
+	if *err == io.EOF {
+		if d.eofOK {
+			d.eofOK = false
+			return
+		}
+		*err = io.ErrUnexpectedEOF
+	}
+	*err = &DecodeError{Offset: d.r.numread(), Path: d.p.String(), Type: d.p.rt, Err: *err}
+}
+
 func (d *Decoder) decode(iv interface{}) {
 	d.d.initReadNext()
 
@@ -501,11 +1037,23 @@ func (d *Decoder) decodeValue(rv reflect.Value) {
 	rt := rv.Type()
 	rvOrig := rv
 	wasNilIntf := rt.Kind() == reflect.Interface && rv.IsNil()
+	if wasNilIntf && rt.NumMethod() > 0 {
+		if xf, _ := d.h.getExtForType(rt, false); xf != nil {
+			// an extension registered for the interface type decodes into it
+			wasNilIntf = false
+		}
+	}
 
 	var ndesc decodeNakedContext
 	//if nil interface, use some hieristics to set the nil interface to an
 	//appropriate value based on the first byte read (byte descriptor bd)
 	if wasNilIntf {
+		if rt.NumMethod() > 0 {
+			if xi := d.h.typeRegistry().forIntf(reflect.ValueOf(rt).Pointer()); xi != nil {
+				d.decodeRegistered(rv, xi)
+				return
+			}
+		}
 		// e.g. nil interface{}, error, io.Reader, etc
 		rv, ndesc = d.d.decodeNaked()
 		if ndesc == dncNil {
@@ -545,7 +1093,7 @@ func (d *Decoder) decodeValue(rv reflect.Value) {
 	fn, ok := d.f[rtid]
 	if !ok {
 		// debugf("\tCreating new dec fn for type: %v\n", rt)
-		fi := decFnInfo { sis:getTypeInfo(rtid, rt), d:d, dd:d.d, rt:rt, rtid:rtid }
+		fi := decFnInfo { sis:d.h.getTypeInfo(rtid, rt), d:d, dd:d.d, rt:rt, rtid:rtid }
 		// An extension can be registered for any type, regardless of the Kind
 		// (e.g. type BitSet int64, type MyStruct { / * unexported fields * / }, type X []int, etc.
 		//
@@ -555,13 +1103,24 @@ func (d *Decoder) decodeValue(rv reflect.Value) {
 		//
 		// If we are checking for builtin or ext type here, it means we didn't go through decodeNaked,
 		// Because decodeNaked would have handled it. It also means wasNilIntf = false.
-		if d.d.isBuiltinType(fi.sis.baseId) {
+		if rtid == rawTypId {
+			fn = decFn { &fi, (*decFnInfo).raw }
+		} else if rtid == rawExtTypId {
+			fn = decFn { &fi, (*decFnInfo).rawExt }
+		} else if xf, xfIndir := d.h.getExtForType(rt, false); xf != nil {
+			fi.xf, fi.xfIndir = xf, xfIndir
+			fn = decFn { &fi, (*decFnInfo).ext }
+		} else if d.d.isBuiltinType(fi.sis.baseId) {
 			fn = decFn { &fi, (*decFnInfo).builtin }
-		} else if xfTag, xfFn := d.h.getDecodeExt(fi.sis.baseId); xfFn != nil {
-			fi.xfTag, fi.xfFn = xfTag, xfFn
+		} else if xf, xfIndir := d.h.getExtForType(rt, true); xf != nil {
+			fi.xf, fi.xfIndir = xf, xfIndir
 			fn = decFn { &fi, (*decFnInfo).ext }
 		} else if supportBinaryMarshal && fi.sis.unm {
 			fn = decFn { &fi, (*decFnInfo).binaryMarshal }
+		} else if fi.sis.tunm {
+			fn = decFn { &fi, (*decFnInfo).textUnmarshal }
+		} else if fi.sis.junm && d.h.useJsonMarshaler() {
+			fn = decFn { &fi, (*decFnInfo).jsonUnmarshal }
 		} else {
 			// NOTE: if decoding into a nil interface{}, we return a non-nil
 			// value except even if the container registers a length of 0.
@@ -597,7 +1156,11 @@ func (d *Decoder) decodeValue(rv reflect.Value) {
 			case reflect.Ptr:
 				fn = decFn { &fi, (*decFnInfo).kPtr }
 			case reflect.Interface:
-				fn = decFn { &fi, (*decFnInfo).kInterface }
+				if rt.NumMethod() > 0 && d.h.typeRegistry().forIntf(rtid) != nil {
+					fn = decFn { &fi, (*decFnInfo).kInterfaceRegistered }
+				} else {
+					fn = decFn { &fi, (*decFnInfo).kInterface }
+				}
 			case reflect.Struct:
 				fn = decFn { &fi, (*decFnInfo).kStruct }
 			case reflect.Slice:
@@ -613,8 +1176,10 @@ func (d *Decoder) decodeValue(rv reflect.Value) {
 		d.f[rtid] = fn
 	}
 	
+	rt0 := d.p.rt
+	d.p.rt = rt
 	fn.f(fn.i, rv)
-	
+	d.p.rt = rt0
 
 	if wasNilIntf {
 		rvOrig.Set(rv)
@@ -622,6 +1187,89 @@ func (d *Decoder) decodeValue(rv reflect.Value) {
 	return
 }
 
+// decodeRegistered decodes into rv, of an interface type with registered types (xi),
+// an array of the marker a concrete type is registered under, and a value of that type.
+// See BasicHandle.RegisterType.
+func (d *Decoder) decodeRegistered(rv reflect.Value, xi *typeRegistryIntf) {
+	if d.d.tryDecodeAsNil() {
+		rv.Set(reflect.Zero(rv.Type()))
+		return
+	}
+	if vt := d.d.currentEncodedType(); vt != detArray {
+		decErr("Expecting array of registered type and value for %v. Got: %v", rv.Type(), ValueType(vt))
+	}
+	d.depthIncr()
+	containerLen := d.d.readArrayLen()
+	if !d.arrayNext(0, containerLen) {
+		decErr("Expecting array of registered type and value for %v. Got empty array", rv.Type())
+	}
+	d.d.initReadNext()
+	var marker interface{}
+	switch vt := d.d.currentEncodedType(); vt {
+	case detString, detBytes:
+		marker = d.d.decodeString()
+	case detUint, detInt, detFloat:
+		// a number may be seen as a float by formats which do not distinguish them (e.g. json)
+		marker = d.d.decodeUint(64)
+	default:
+		decErr("Expecting name or tag of registered type for %v. Got: %v", rv.Type(), ValueType(vt))
+	}
+	rt, ok := xi.markers[marker]
+	if !ok {
+		decErr("No type registered as %v (for %v)", marker, rv.Type())
+	}
+	if !d.arrayNext(1, containerLen) {
+		decErr("Expecting array of registered type and value for %v. Got no value", rv.Type())
+	}
+	rvn := reflect.New(rt).Elem()
+	d.decodeValue(rvn)
+	if d.arrayNext(2, containerLen) {
+		decErr("Expecting array of registered type and value for %v. Got more than 2 elements", rv.Type())
+	}
+	rv.Set(rvn)
+	d.depth--
+}
+
+func (d *Decoder) depthIncr() {
+	d.depth++
+	d.o.checkDepth(d.depth)
+}
+
+// arrayNext is called before reading the element at index j of an array.
+// It reports whether that element exists, and consumes any separator before it.
+// A containerLen < 0 means the length was not known up front.
+func (d *Decoder) arrayNext(j, containerLen int) bool {
+	if containerLen < 0 {
+		if d.d.checkBreak() {
+			return false
+		}
+		d.o.checkContainerLen(j + 1)
+	} else if j >= containerLen {
+		return false
+	}
+	if j > 0 {
+		d.d.readArrayEntrySeparator()
+	}
+	return true
+}
+
+// mapNext is like arrayNext, but is called before reading the key
+// of the entry at index j of a map.
+func (d *Decoder) mapNext(j, containerLen int) bool {
+	if containerLen < 0 {
+		if d.d.checkBreak() {
+			return false
+		}
+		d.o.checkContainerLen(j + 1)
+	} else if j >= containerLen {
+		return false
+	}
+	if j > 0 {
+		d.d.readMapEntrySeparator()
+	}
+	return true
+}
+
 func (d *Decoder) chkPtrValue(rv reflect.Value) {
 	// We cannot marshal into a non-pointer or a nil pointer
 	// (at least pass a nil interface so we can marshal into it)
@@ -638,31 +1286,111 @@ func (d *Decoder) chkPtrValue(rv reflect.Value) {
 // ------------------------------------
 
 func (z *ioDecReader) readn(n int) (bs []byte) {
+	z.checkLimit(z.n, n)
 	bs = make([]byte, n)
-	if _, err := io.ReadAtLeast(z.r, bs, n); err != nil {
-		panic(err)
-	}
+	z.readb(bs)
 	return
 }
 
 func (z *ioDecReader) readb(bs []byte) {	
-	if _, err := io.ReadAtLeast(z.r, bs, len(bs)); err != nil {
+	z.checkLimit(z.n, len(bs))
+	n, err := io.ReadAtLeast(z.r, bs, len(bs))
+	z.n += int64(n)
+	if z.trb {
+		z.tr = append(z.tr, bs[:n]...)
+	}
+	if err != nil {
 		panic(err)
 	}
 }
 
 func (z *ioDecReader) readn1() uint8 {
 	if z.br != nil {
+		z.checkLimit(z.n, 1)
 		b, err := z.br.ReadByte()
 		if err != nil {
 			panic(err)
 		}
+		z.n++
+		if z.trb {
+			z.tr = append(z.tr, b)
+		}
 		return b
 	}
 	z.readb(z.x[:1])
 	return z.x[0]
 }
 
+func (z *ioDecReader) readn1eof() (b uint8, eof bool) {
+	if z.atLimit(z.n) {
+		return 0, true
+	}
+	var err error
+	if z.br != nil {
+		b, err = z.br.ReadByte()
+	} else {
+		var n int
+		if n, err = z.r.Read(z.x[:1]); n == 1 {
+			b, err = z.x[0], nil
+		} else if err == nil {
+			// a Reader may return 0, nil. Try again, as io.ReadAtLeast would.
+			_, err = io.ReadAtLeast(z.r, z.x[:1], 1)
+			b = z.x[0]
+		}
+	}
+	if err == io.EOF {
+		eof = true
+	} else if err != nil {
+		panic(err)
+	} else {
+		z.n++
+		if z.trb {
+			z.tr = append(z.tr, b)
+		}
+	}
+	return
+}
+
+func (z *ioDecReader) skip(n int) {
+	if n <= 0 {
+		return
+	}
+	z.checkLimit(z.n, n)
+	if z.trb {
+		// read in chunks, so a bogus length in the stream does not allocate it all up front
+		var buf [512]byte
+		for n > 0 {
+			m := n
+			if m > len(buf) {
+				m = len(buf)
+			}
+			z.readb(buf[:m])
+			n -= m
+		}
+		return
+	}
+	n2, err := io.CopyN(ioutil.Discard, z.r, int64(n))
+	z.n += n2
+	if err == io.EOF {
+		panic(io.ErrUnexpectedEOF)
+	} else if err != nil {
+		panic(err)
+	}
+}
+
+func (z *ioDecReader) numread() int64 {
+	return z.n
+}
+
+func (z *ioDecReader) track() {
+	z.tr, z.trb = z.tr[:0], true
+}
+
+func (z *ioDecReader) stopTrack() []byte {
+	z.trb = false
+	return z.tr
+}
+
 func (z *ioDecReader) readUint16() uint16 {
 	z.readb(z.x[:2])
 	return bigen.Uint16(z.x[:2])
@@ -681,11 +1409,15 @@ func (z *ioDecReader) readUint64() uint64 {
 // ------------------------------------
 
 func (z *bytesDecReader) consume(n int) (oldcursor int) {
+	if n == 0 {
+		return z.c
+	}
+	z.checkLimit(int64(z.c), n)
 	if z.a == 0 {
 		panic(io.EOF)
 	}
 	if n > z.a {
-		doPanic(msgTagDec, "Trying to read %v bytes. Only %v available", n, z.a)
+		panic(io.ErrUnexpectedEOF)
 	}
 	// z.checkAvailable(n)
 	oldcursor = z.c
@@ -709,6 +1441,29 @@ func (z *bytesDecReader) readn1() uint8 {
 	return z.b[c0]
 }
 
+func (z *bytesDecReader) readn1eof() (v uint8, eof bool) {
+	if z.a == 0 || z.atLimit(int64(z.c)) {
+		return 0, true
+	}
+	return z.readn1(), false
+}
+
+func (z *bytesDecReader) skip(n int) {
+	z.consume(n)
+}
+
+func (z *bytesDecReader) track() {
+	z.t = z.c
+}
+
+func (z *bytesDecReader) stopTrack() []byte {
+	return z.b[z.t:z.c]
+}
+
+func (z *bytesDecReader) numread() int64 {
+	return int64(z.c)
+}
+
 // Use binaryEncoding helper for 4 and 8 bits, but inline it for 2 bits
 // creating temp slice variable and copying it to helper function is expensive
 // for just 2 bits.
@@ -730,6 +1485,15 @@ func (z *bytesDecReader) readUint64() uint64 {
 
 // ----------------------------------------
 
+// decRawBytes returns a copy of the bytes of a value, 
+// given its first byte bd and the rest of its bytes.
+func decRawBytes(bd byte, rest []byte) []byte {
+	bs := make([]byte, len(rest)+1)
+	bs[0] = bd
+	copy(bs[1:], rest)
+	return bs
+}
+
 func decErr(format string, params ...interface{}) {
 	doPanic(msgTagDec, format, params...)
 }
@@ -5,8 +5,11 @@ package codec
 
 import (
 	//"bufio"
+	"fmt"
 	"io"
+	"math"
 	"reflect"
+	"sort"
 	//"fmt"
 )
 
@@ -42,20 +45,42 @@ type encDriver interface {
 	encodeFloat64(f float64)
 	encodeExtPreamble(xtag byte, length int)
 	encodeArrayPreamble(length int)
+	encodeArrayEntrySeparator()
+	encodeArrayEnd()
 	encodeMapPreamble(length int)
+	encodeMapEntrySeparator()
+	encodeMapKVSeparator()
+	encodeMapEnd()
 	encodeString(c charEncoding, v string)
 	encodeSymbol(v string)
 	encodeStringBytes(c charEncoding, v []byte)
+	// reset prepares the driver for writing to a new stream.
+	reset(w encWriter)
 	//TODO
 	//encBignum(f *big.Int)
 	//encStringRunes(c charEncoding, v []rune)
 }
 
+// encNoSeparator is embedded by encDrivers for formats which do not write
+// any separators or end markers around container entries (e.g. msgpack, binc).
+type encNoSeparator struct{}
+
+func (_ encNoSeparator) encodeArrayEntrySeparator() {}
+func (_ encNoSeparator) encodeArrayEnd()            {}
+func (_ encNoSeparator) encodeMapEntrySeparator()   {}
+func (_ encNoSeparator) encodeMapKVSeparator()      {}
+func (_ encNoSeparator) encodeMapEnd()              {}
+
 // encodeHandleI is the interface that the encode functions need.
 type encodeHandleI interface {
-	getEncodeExt(rt uintptr) (tag byte, fn func(reflect.Value) ([]byte, error))
+	getTypeInfo(rtid uintptr, rt reflect.Type) *typeInfo
+	typeRegistry() *typeRegistry
+	useJsonMarshaler() bool
+	getExtForType(rt reflect.Type, intf bool) (x *extTypeTagFn, indir int8)
 	writeExt() bool
+	nativeJson() bool
 	structToArray() bool
+	canonical() bool
 }
 
 type encFnInfo struct {
@@ -64,8 +89,8 @@ type encFnInfo struct {
 	ee    encDriver
 	rt    reflect.Type
 	rtid  uintptr
-	xfFn  func(reflect.Value) ([]byte, error)
-	xfTag byte 
+	xf    *extTypeTagFn
+	xfIndir int8
 }
 
 // encFn encapsulates the captured variables and the encode function.
@@ -83,6 +108,30 @@ type Encoder struct {
 	e encDriver
 	h encodeHandleI
 	f map[uintptr]encFn
+	p valuePath
+	// tok holds the arrays and maps being written using WriteArrayStart/WriteMapStart.
+	tok []tokContainer
+
+	// writers are kept here, so they can be reused on Reset
+	wi ioEncWriter
+	ws simpleIoEncWriterWriter
+	wb bytesEncWriter
+}
+
+// EncodeError is the error returned when encoding fails.
+//
+// It records where in the value being encoded the error happened.
+type EncodeError struct {
+	Path string       // path to the value being encoded, e.g. Outer.Items[3].Name
+	Type reflect.Type // type of the value being encoded (if known)
+	Err  error        // underlying error
+}
+
+func (e *EncodeError) Error() string {
+	if s := errContext(e.Path, e.Type); s != "" {
+		return fmt.Sprintf("%v (%s)", e.Err, s)
+	}
+	return e.Err.Error()
 }
 
 type ioEncWriterWriter interface {
@@ -118,6 +167,14 @@ type bytesEncWriter struct {
 type EncodeOptions struct {
 	// Encode a struct as an array, and not as a map.
 	StructToArray bool
+	// Canonical ensures that a value is always encoded to the same bytes
+	// (e.g. so that encoded payloads can be hashed or signed):
+	//   - map keys are sorted (nil < bool < numbers < strings < others).
+	//     Numbers are sorted by value, strings bytewise,
+	//     and other keys bytewise by their encoded form.
+	//   - numbers are written in the smallest form for their value,
+	//     regardless of their Go type (e.g. int64(1) and uint8(1) encode the same).
+	Canonical bool
 }
 
 func (o *simpleIoEncWriterWriter) WriteByte(c byte) (err error) {
@@ -144,20 +201,64 @@ func (o *EncodeOptions) structToArray() bool {
 	return o.StructToArray
 }
 
+func (o *EncodeOptions) canonical() bool {
+	return o.Canonical
+}
+
+func (f *encFnInfo) raw(rv reflect.Value) {
+	if bs := rv.Bytes(); len(bs) == 0 {
+		f.ee.encodeNil()
+	} else {
+		f.e.w.writeb(bs)
+	}
+}
+
 func (f *encFnInfo) builtin(rv reflect.Value) {
 	baseRv := rv
 	for j := int8(0); j < f.sis.baseIndir; j++ {
+		if baseRv.IsNil() {
+			f.ee.encodeNil()
+			return
+		}
 		baseRv = baseRv.Elem()
 	}
 	f.ee.encodeBuiltinType(f.sis.baseId, baseRv)
 }
 
+func (f *encFnInfo) rawExt(rv reflect.Value) {
+	re := rv.Interface().(RawExt)
+	if f.e.h.writeExt() {
+		f.ee.encodeExtPreamble(re.Tag, len(re.Data))
+		f.e.w.writeb(re.Data)
+	} else {
+		// as for other extensions, only the data is written (e.g. base64 in json)
+		f.ee.encodeStringBytes(c_RAW, re.Data)
+	}
+}
+
 func (f *encFnInfo) ext(rv reflect.Value) {
 	baseRv := rv
-	for j := int8(0); j < f.sis.baseIndir; j++ {
+	for j := int8(0); j < f.xfIndir; j++ {
+		if baseRv.IsNil() {
+			f.ee.encodeNil()
+			return
+		}
 		baseRv = baseRv.Elem()
 	}
-	bs, fnerr := f.xfFn(baseRv)
+	if k := baseRv.Kind(); (k == reflect.Ptr || k == reflect.Interface) && baseRv.IsNil() {
+		f.ee.encodeNil()
+		return
+	}
+	if f.xf.ext != nil && !f.e.h.writeExt() {
+		// encode the intermediate value in place
+		v, fnerr := f.xf.ext.ConvertExt(baseRv.Interface())
+		if fnerr != nil {
+			panic(fnerr)
+		}
+		f.e.encode(v)
+		return
+	}
+	bs, fnerr := f.xf.encode(f.e.h.(Handle), baseRv)
 	if fnerr != nil {
 		panic(fnerr)
 	}
@@ -166,7 +267,7 @@ func (f *encFnInfo) ext(rv reflect.Value) {
 		return
 	}
 	if f.e.h.writeExt() {
-		f.ee.encodeExtPreamble(f.xfTag, len(bs))
+		f.ee.encodeExtPreamble(f.xf.tag, len(bs))
 		f.e.w.writeb(bs)
 	} else {
 		f.ee.encodeStringBytes(c_RAW, bs)
@@ -200,6 +301,38 @@ func (f *encFnInfo) binaryMarshal(rv reflect.Value) {
 
 }
 
+func (f *encFnInfo) textMarshal(rv reflect.Value) {
+	for rv2 := rv; rv2.Kind() == reflect.Ptr; rv2 = rv2.Elem() {
+		if rv2.IsNil() {
+			f.ee.encodeNil()
+			return
+		}
+	}
+	bs, fnerr := indirIntf(rv, f.sis.tmIndir).(textMarshaler).MarshalText()
+	if fnerr != nil {
+		panic(fnerr)
+	}
+	f.ee.encodeStringBytes(c_UTF8, bs)
+}
+
+func (f *encFnInfo) jsonMarshal(rv reflect.Value) {
+	for rv2 := rv; rv2.Kind() == reflect.Ptr; rv2 = rv2.Elem() {
+		if rv2.IsNil() {
+			f.ee.encodeNil()
+			return
+		}
+	}
+	bs, fnerr := indirIntf(rv, f.sis.jmIndir).(jsonMarshaler).MarshalJSON()
+	if fnerr != nil {
+		panic(fnerr)
+	}
+	if f.e.h.nativeJson() {
+		f.e.w.writeb(bs)
+	} else {
+		f.ee.encodeStringBytes(c_UTF8, bs)
+	}
+}
+
 func (f *encFnInfo) kBool(rv reflect.Value) {
 	f.ee.encodeBool(rv.Bool())
 }
@@ -242,30 +375,41 @@ func (f *encFnInfo) kSlice(rv reflect.Value) {
 		return
 	}
 	l := rv.Len()
-	f.ee.encodeArrayPreamble(l)
-	if l == 0 {
-		return
-	}
+	ee := f.ee
+	ee.encodeArrayPreamble(l)
+	f.e.p.pushIndex(0)
 	for j := 0; j < l; j++ {
+		if j > 0 {
+			ee.encodeArrayEntrySeparator()
+		}
+		f.e.p.setIndex(j)
 		f.e.encodeValue(rv.Index(j))
 	}
+	f.e.p.pop()
+	ee.encodeArrayEnd()
 }
 
 func (f *encFnInfo) kArray(rv reflect.Value) {
+	// an array can only be sliced if addressable (e.g. not if it is a map key or in an interface)
+	if !rv.CanAddr() {
+		rv2 := reflect.New(f.rt).Elem()
+		rv2.Set(rv)
+		rv = rv2
+	}
 	f.e.encodeValue(rv.Slice(0, rv.Len()))
 }
 
 func (f *encFnInfo) kStruct(rv reflect.Value) {
 	newlen := len(f.sis.sis)
 	rvals := make([]reflect.Value, newlen)
-	var encnames []string
+	fsis := make([]*structFieldInfo, newlen)
 	e := f.e
 	sissis := f.sis.sisp
-	toMap := !(f.sis.toArray || e.h.structToArray())
+	// a struct with an unknown field is always encoded as a map, so its unknown keys are kept
+	toMap := f.sis.unknown != nil || !(f.sis.toArray || e.h.structToArray())
 	// if toMap, use the sorted array. If toArray, use unsorted array (to match sequence in struct)
 	if toMap {
 		sissis = f.sis.sis
-		encnames = make([]string, newlen)
 	}
 	newlen = 0
 	for _, si := range sissis {
@@ -274,11 +418,11 @@ func (f *encFnInfo) kStruct(rv reflect.Value) {
 		} else {
 			rvals[newlen] = rv.FieldByIndex(si.is)
 		}
+		fsis[newlen] = si
 		if toMap {
 			if si.omitEmpty && isEmptyValue(rvals[newlen]) {
 				continue
 			}
-			encnames[newlen] = si.encName
 		} else {
 			if si.omitEmpty && isEmptyValue(rvals[newlen]) {
 				rvals[newlen] = reflect.Value{} //encode as nil
@@ -287,18 +431,61 @@ func (f *encFnInfo) kStruct(rv reflect.Value) {
 		newlen++
 	}
 
+	// the keys in the field with the unknown tag option are written after the fields,
+	// skipping any which match a field.
+	var ukeys []string
+	var rvu reflect.Value
+	if f.sis.unknown != nil {
+		if rvu = f.sis.unknown.field(rv); rvu.Len() > 0 {
+			for _, rvk := range rvu.MapKeys() {
+				if k := rvk.String(); f.sis.indexForEncName(k) < 0 {
+					ukeys = append(ukeys, k)
+				}
+			}
+			if e.h.canonical() {
+				sort.Strings(ukeys)
+			}
+		}
+	}
+
+	ee := f.ee //don't dereference everytime
 	if toMap {
-		ee := f.ee //don't dereference everytime
-		ee.encodeMapPreamble(newlen)
+		ee.encodeMapPreamble(newlen + len(ukeys))
 		for j := 0; j < newlen; j++ {
-			ee.encodeSymbol(encnames[j])
+			if j > 0 {
+				ee.encodeMapEntrySeparator()
+			}
+			ee.encodeSymbol(fsis[j].encName)
+			ee.encodeMapKVSeparator()
+			e.p.pushName(fsis[j].name)
 			e.encodeValue(rvals[j])
+			e.p.pop()
 		}
+		for j, k := range ukeys {
+			if newlen+j > 0 {
+				ee.encodeMapEntrySeparator()
+			}
+			ee.encodeSymbol(k)
+			ee.encodeMapKVSeparator()
+			rvk := reflect.ValueOf(k).Convert(rvu.Type().Key())
+			e.p.pushName(f.sis.unknown.name)
+			e.p.pushKey(rvk)
+			e.encodeValue(rvu.MapIndex(rvk))
+			e.p.pop()
+			e.p.pop()
+		}
+		ee.encodeMapEnd()
 	} else {
-		f.ee.encodeArrayPreamble(newlen)
+		ee.encodeArrayPreamble(newlen)
 		for j := 0; j < newlen; j++ {
+			if j > 0 {
+				ee.encodeArrayEntrySeparator()
+			}
+			e.p.pushName(fsis[j].name)
 			e.encodeValue(rvals[j])
+			e.p.pop()
 		}
+		ee.encodeArrayEnd()
 	}
 }
 
@@ -318,49 +505,224 @@ func (f *encFnInfo) kInterface(rv reflect.Value) {
 	f.e.encodeValue(rv.Elem())
 }
 
+// kInterfaceRegistered encodes a value of an interface type with registered types,
+// as an array of the marker its concrete type is registered under, and the value.
+func (f *encFnInfo) kInterfaceRegistered(rv reflect.Value) {
+	if rv.IsNil() {
+		f.ee.encodeNil()
+		return
+	}
+	rv = rv.Elem()
+	marker, ok := f.e.h.typeRegistry().forIntf(f.rtid).types[reflect.ValueOf(rv.Type()).Pointer()]
+	if !ok {
+		encErr("Type %v (in interface %v) is not registered", rv.Type(), f.rt)
+	}
+	ee := f.ee
+	ee.encodeArrayPreamble(2)
+	switch v := marker.(type) {
+	case string:
+		ee.encodeString(c_UTF8, v)
+	case uint64:
+		ee.encodeUint(v)
+	}
+	ee.encodeArrayEntrySeparator()
+	f.e.encodeValue(rv)
+	ee.encodeArrayEnd()
+}
+
 func (f *encFnInfo) kMap(rv reflect.Value) {
 	if rv.IsNil() {
 		f.ee.encodeNil()
 		return
 	}
 	l := rv.Len()
-	f.ee.encodeMapPreamble(l)
+	ee := f.ee
+	ee.encodeMapPreamble(l)
 	if l == 0 {
+		ee.encodeMapEnd()
 		return
 	}
-	keyTypeIsString := f.rt.Key().Kind() == reflect.String
+	// keys implementing TextMarshaler are encoded using it (in encodeValue), 
+	// even if they are strings.
+	kt := f.rt.Key()
+	keyTypeIsString := kt.Kind() == reflect.String && 
+		!f.e.h.getTypeInfo(reflect.ValueOf(kt).Pointer(), kt).tm
 	mks := rv.MapKeys()
+	if f.e.h.canonical() {
+		f.e.sortMapKeys(mks)
+	}
 	// for j, lmks := 0, len(mks); j < lmks; j++ {
 	for j := range mks {
+		if j > 0 {
+			ee.encodeMapEntrySeparator()
+		}
 		if keyTypeIsString {
-			f.ee.encodeSymbol(mks[j].String())
+			ee.encodeSymbol(mks[j].String())
 		} else {
 			f.e.encodeValue(mks[j])
 		}
+		ee.encodeMapKVSeparator()
+		f.e.p.pushKey(mks[j])
 		f.e.encodeValue(rv.MapIndex(mks[j]))
+		f.e.p.pop()
 	}
+	ee.encodeMapEnd()
+}
+
 
+
+// canonicalMapKey holds a map key, and what it is sorted by in canonical mode.
+type canonicalMapKey struct {
+	v   reflect.Value
+	cls uint8 // 0: nil, 1: bool, 2: number, 3: string, 4: other
+	k   reflect.Kind
+	neg bool // negative integer (value in i), else non-negative integer (or bool) in u
+	i   int64
+	u   uint64
+	f   float64
+	s   string // string value, or encoded bytes for other keys
 }
 
+type canonicalMapKeys []canonicalMapKey
+
+func (p canonicalMapKeys) Len() int      { return len(p) }
+func (p canonicalMapKeys) Swap(i, j int) { p[i], p[j] = p[j], p[i] }
+
+func (p canonicalMapKeys) Less(i, j int) bool {
+	a, b := &p[i], &p[j]
+	if a.cls != b.cls {
+		return a.cls < b.cls
+	}
+	switch a.cls {
+	case 1:
+		return a.u < b.u
+	case 2:
+		if c := compareNumbers(a, b); c != 0 {
+			return c < 0
+		}
+		return a.k < b.k
+	case 3, 4:
+		return a.s < b.s
+	}
+	return false
+}
 
+// compareNumbers compares the values of 2 numeric map keys, returning -1, 0 or 1.
+func compareNumbers(a, b *canonicalMapKey) int {
+	aFloat := a.k == reflect.Float32 || a.k == reflect.Float64
+	bFloat := b.k == reflect.Float32 || b.k == reflect.Float64
+	if !aFloat && !bFloat {
+		switch {
+		case a.neg != b.neg:
+			if a.neg {
+				return -1
+			}
+			return 1
+		case a.neg && a.i != b.i:
+			if a.i < b.i {
+				return -1
+			}
+			return 1
+		case !a.neg && a.u != b.u:
+			if a.u < b.u {
+				return -1
+			}
+			return 1
+		}
+		return 0
+	}
+	af, bf := a.f, b.f
+	if !aFloat {
+		if af = float64(a.u); a.neg {
+			af = float64(a.i)
+		}
+	}
+	if !bFloat {
+		if bf = float64(b.u); b.neg {
+			bf = float64(b.i)
+		}
+	}
+	// NaN sorts before all other numbers
+	switch aNaN, bNaN := math.IsNaN(af), math.IsNaN(bf); {
+	case aNaN && bNaN:
+		return 0
+	case aNaN:
+		return -1
+	case bNaN:
+		return 1
+	case af < bf:
+		return -1
+	case af > bf:
+		return 1
+	}
+	return 0
+}
+
+// sortMapKeys sorts the keys of a map in the canonical order.
+func (e *Encoder) sortMapKeys(mks []reflect.Value) {
+	cks := make(canonicalMapKeys, len(mks))
+	// non-scalar keys are compared by their encoded bytes,
+	// using one scratch encoder (and buffer) for all of them.
+	var ee *Encoder
+	var bs []byte
+	for j, v := range mks {
+		ck := &cks[j]
+		ck.v = v
+		if v.Kind() == reflect.Interface {
+			if v.IsNil() {
+				continue // cls 0: nil
+			}
+			v = v.Elem()
+		}
+		switch ck.k = v.Kind(); ck.k {
+		case reflect.Bool:
+			ck.cls = 1
+			if v.Bool() {
+				ck.u = 1
+			}
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			ck.cls = 2
+			if ck.i = v.Int(); ck.i < 0 {
+				ck.neg = true
+			} else {
+				ck.u = uint64(ck.i)
+			}
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+			ck.cls = 2
+			ck.u = v.Uint()
+		case reflect.Float32, reflect.Float64:
+			ck.cls = 2
+			ck.f = v.Float()
+		case reflect.String:
+			ck.cls = 3
+			ck.s = v.String()
+		default:
+			ck.cls = 4
+			if ee == nil {
+				ee = NewEncoderBytes(&bs, e.h.(Handle))
+			} else {
+				ee.ResetBytes(&bs)
+			}
+			ee.encodeValue(v)
+			ee.w.atEndOfEncode()
+			ck.s = string(bs)
+		}
+	}
+	sort.Sort(cks)
+	for j := range cks {
+		mks[j] = cks[j].v
+	}
+}
 
 // NewEncoder returns an Encoder for encoding into an io.Writer.
 // 
 // For efficiency, Users are encouraged to pass in a memory buffered writer
 // (eg bufio.Writer, bytes.Buffer). 
 func NewEncoder(w io.Writer, h Handle) *Encoder {
-	ww, ok := w.(ioEncWriterWriter)
-	if !ok {
-		sww := simpleIoEncWriterWriter{w: w}
-		sww.bw, _ = w.(io.ByteWriter)
-		sww.sw, _ = w.(ioEncStringWriter)
-		ww = &sww
-		//ww = bufio.NewWriterSize(w, defEncByteBufSize)
-	}
-	z := ioEncWriter{
-		w: ww,
-	}
-	return &Encoder{w: &z, h: h, e: h.newEncDriver(&z) }
+	e := &Encoder{h: h}
+	e.resetIo(w)
+	e.e = h.newEncDriver(e.w)
+	return e
 }
 
 // NewEncoderBytes returns an encoder for encoding directly and efficiently
@@ -369,15 +731,55 @@ func NewEncoder(w io.Writer, h Handle) *Encoder {
 // It will potentially replace the output byte slice pointed to.
 // After encoding, the out parameter contains the encoded contents.
 func NewEncoderBytes(out *[]byte, h Handle) *Encoder {
+	e := &Encoder{h: h}
+	e.resetBytes(out)
+	e.e = h.newEncDriver(e.w)
+	return e
+}
+
+// Reset resets the Encoder to write to a new io.Writer.
+//
+// The Encoder keeps the functions it has cached for the types it has seen,
+// making it cheaper than creating a new Encoder (e.g. when pooled using a sync.Pool).
+// Symbols (for formats which support them e.g. binc) are not kept.
+func (e *Encoder) Reset(w io.Writer) {
+	e.resetIo(w)
+	e.e.reset(e.w)
+	e.tok = e.tok[:0]
+}
+
+// ResetBytes resets the Encoder to write to a new byte slice (see NewEncoderBytes).
+func (e *Encoder) ResetBytes(out *[]byte) {
+	e.resetBytes(out)
+	e.e.reset(e.w)
+	e.tok = e.tok[:0]
+}
+
+func (e *Encoder) resetIo(w io.Writer) {
+	ww, ok := w.(ioEncWriterWriter)
+	if !ok {
+		e.ws = simpleIoEncWriterWriter{w: w}
+		e.ws.bw, _ = w.(io.ByteWriter)
+		e.ws.sw, _ = w.(ioEncStringWriter)
+		ww = &e.ws
+		//ww = bufio.NewWriterSize(w, defEncByteBufSize)
+	}
+	e.wi = ioEncWriter{
+		w: ww,
+	}
+	e.w = &e.wi
+}
+
+func (e *Encoder) resetBytes(out *[]byte) {
 	in := *out
 	if in == nil {
 		in = make([]byte, defEncByteBufSize)
 	}
-	z := bytesEncWriter{
+	e.wb = bytesEncWriter{
 		b:   in,
 		out: out,
 	}
-	return &Encoder{w: &z, h: h, e: h.newEncDriver(&z) }
+	e.w = &e.wb
 }
 
 // Encode writes an object into a stream in the codec format.
@@ -401,6 +803,13 @@ func NewEncoderBytes(out *[]byte, h Handle) *Encoder {
 //    - StructToArray Encode option is set, OR
 //    - the codec tag on the _struct field sets the "toarray" option
 // 
+// The "unknown" option marks a map field with string keys (e.g. map[string]interface{},
+// or map[string]Raw to keep the exact encoded bytes of each value). On decode, the keys
+// matching no field (and their values) are stored in it, and on encode they are written
+// after the fields. A struct with an unknown field is always encoded as a map (even if
+// StructToArray is set), and cannot set the "toarray" option. The field must be a map:
+// a single Raw (or []byte) field holding all the unknown keys is not supported.
+// 
 // The empty values (for omitempty option) are false, 0, any nil pointer 
 // or interface value, and any array, slice, map, or string of length zero.
 //
@@ -415,6 +824,9 @@ func NewEncoderBytes(out *[]byte, h Handle) *Encoder {
 //          Field2 int      `codec:"myName"`       //Use key "myName" in encode stream
 //          Field3 int32    `codec:",omitempty"`   //use key "Field3". Omit if empty.
 //          Field4 bool     `codec:"f4,omitempty"` //use key "f4". Omit if empty.
+//          Field5 int      `codec:"f5,alias=g5|h5"` //use key "f5". Also decode from keys "g5" or "h5".
+//          Rest map[string]interface{} `codec:",unknown"` //keys matching no field are decoded into
+//                                                       //this map, and encoded after the fields.
 //          ...
 //      }
 //      
@@ -426,18 +838,221 @@ func NewEncoderBytes(out *[]byte, h Handle) *Encoder {
 // The mode of encoding is based on the type of the value. When a value is seen:
 //   - If an extension is registered for it, call that extension function
 //   - If it implements BinaryMarshaler, call its MarshalBinary() (data []byte, err error)
+//   - If it implements TextMarshaler, call its MarshalText() (text []byte, err error),
+//     and encode the text as a string (also when it is a map key)
 //   - Else encode it based on its reflect.Kind
 // 
 // Note that struct field names and keys in map[string]XXX will be treated as symbols.
 // Some formats support symbols (e.g. binc) and will properly encode the string
 // only once in the stream, and use a tag to refer to it thereafter. 
+// 
+// Encode can also be used to write the elements of an array or map
+// started using WriteArrayStart or WriteMapStart.
 func (e *Encoder) Encode(v interface{}) (err error) {
+	defer e.wrapErr(&err)
 	defer panicToErr(&err)
+	if rv, ok := v.(reflect.Value); ok && rv.IsValid() {
+		e.p.reset(rv.Type())
+	} else {
+		e.p.reset(reflect.TypeOf(v))
+	}
+	e.tokBefore()
 	e.encode(v)
+	e.tokAfter()
+	return
+}
+
+// WriteArrayStart writes the start of an array of the given length.
+// 
+// Together with WriteMapStart, WriteEnd, Encode and the WriteXXX methods for 
+// primitive values, it allows writing a stream one value at a time, 
+// without first building a whole container. E.g.
+//   err = enc.WriteArrayStart(len(rows))
+//   for _, row := range rows {
+//       err = enc.Encode(row)
+//   }
+//   err = enc.WriteEnd()
+// 
+// Exactly length elements must be written before calling WriteEnd.
+func (e *Encoder) WriteArrayStart(length int) error {
+	return e.writeContainerStart(false, length)
+}
+
+// WriteMapStart writes the start of a map of the given length.
+// 
+// Exactly length entries must be written before calling WriteEnd,
+// where each entry is written as a key followed by its value.
+// Note that the entries are not sorted, even if the Canonical option is set.
+func (e *Encoder) WriteMapStart(length int) error {
+	return e.writeContainerStart(true, length)
+}
+
+func (e *Encoder) writeContainerStart(m bool, length int) (err error) {
+	defer e.wrapErr(&err)
+	defer panicToErr(&err)
+	e.p.reset(nil)
+	if length < 0 {
+		encErr("Invalid length for array or map: %v", length)
+	}
+	e.tokBefore()
+	if m {
+		e.e.encodeMapPreamble(length)
+	} else {
+		e.e.encodeArrayPreamble(length)
+	}
+	e.tok = append(e.tok, tokContainer{n: length, m: m})
 	e.w.atEndOfEncode()
 	return
 }
 
+// WriteEnd writes the end of the array or map last started 
+// using WriteArrayStart or WriteMapStart.
+func (e *Encoder) WriteEnd() (err error) {
+	defer e.wrapErr(&err)
+	defer panicToErr(&err)
+	e.p.reset(nil)
+	n := len(e.tok)
+	if n == 0 {
+		encErr("WriteEnd: No array or map is being written")
+	}
+	t := e.tok[n-1]
+	if t.j != t.n || t.rd != 0 {
+		encErr("WriteEnd: Wrote %v elements (and %v values of next element). Expecting: %v", t.j, t.rd, t.n)
+	}
+	if t.m {
+		e.e.encodeMapEnd()
+	} else {
+		e.e.encodeArrayEnd()
+	}
+	e.tok = e.tok[:n-1]
+	e.tokAfter()
+	return
+}
+
+// WriteNil writes a nil value.
+func (e *Encoder) WriteNil() (err error) {
+	defer e.wrapErr(&err)
+	defer panicToErr(&err)
+	e.p.reset(nil)
+	e.tokBefore()
+	e.e.encodeNil()
+	e.tokAfter()
+	return
+}
+
+// WriteBool writes a bool value.
+func (e *Encoder) WriteBool(v bool) (err error) {
+	defer e.wrapErr(&err)
+	defer panicToErr(&err)
+	e.p.reset(nil)
+	e.tokBefore()
+	e.e.encodeBool(v)
+	e.tokAfter()
+	return
+}
+
+// WriteInt writes a signed integer value.
+func (e *Encoder) WriteInt(v int64) (err error) {
+	defer e.wrapErr(&err)
+	defer panicToErr(&err)
+	e.p.reset(nil)
+	e.tokBefore()
+	e.e.encodeInt(v)
+	e.tokAfter()
+	return
+}
+
+// WriteUint writes an unsigned integer value.
+func (e *Encoder) WriteUint(v uint64) (err error) {
+	defer e.wrapErr(&err)
+	defer panicToErr(&err)
+	e.p.reset(nil)
+	e.tokBefore()
+	e.e.encodeUint(v)
+	e.tokAfter()
+	return
+}
+
+// WriteFloat64 writes a floating point value.
+func (e *Encoder) WriteFloat64(v float64) (err error) {
+	defer e.wrapErr(&err)
+	defer panicToErr(&err)
+	e.p.reset(nil)
+	e.tokBefore()
+	e.e.encodeFloat64(v)
+	e.tokAfter()
+	return
+}
+
+// WriteString writes a string value.
+func (e *Encoder) WriteString(v string) (err error) {
+	defer e.wrapErr(&err)
+	defer panicToErr(&err)
+	e.p.reset(nil)
+	e.tokBefore()
+	e.e.encodeString(c_UTF8, v)
+	e.tokAfter()
+	return
+}
+
+// WriteBytes writes a raw byte slice value.
+func (e *Encoder) WriteBytes(v []byte) (err error) {
+	defer e.wrapErr(&err)
+	defer panicToErr(&err)
+	e.p.reset(nil)
+	e.tokBefore()
+	if v == nil {
+		e.e.encodeNil()
+	} else {
+		e.e.encodeStringBytes(c_RAW, v)
+	}
+	e.tokAfter()
+	return
+}
+
+// tokBefore is called before writing a value, to check that it fits 
+// in the container being written (if any), and to write any separator before it.
+func (e *Encoder) tokBefore() {
+	n := len(e.tok)
+	if n == 0 {
+		return
+	}
+	t := &e.tok[n-1]
+	if t.rd == 1 {
+		e.e.encodeMapKVSeparator()
+		return
+	}
+	if t.j >= t.n {
+		encErr("Cannot write more than %v elements into array or map", t.n)
+	}
+	if t.j > 0 {
+		if t.m {
+			e.e.encodeMapEntrySeparator()
+		} else {
+			e.e.encodeArrayEntrySeparator()
+		}
+	}
+	t.j++
+}
+
+// tokAfter is called after writing a value.
+func (e *Encoder) tokAfter() {
+	if n := len(e.tok); n > 0 {
+		t := &e.tok[n-1]
+		if t.rd++; t.rd == t.size() {
+			t.rd = 0
+		}
+	}
+	e.w.atEndOfEncode()
+}
+
+// wrapErr converts an error returned by Encode into an *EncodeError.
+func (e *Encoder) wrapErr(err *error) {
+	if *err != nil {
+		*err = &EncodeError{Path: e.p.String(), Type: e.p.rt, Err: *err}
+	}
+}
+
 func (e *Encoder) encode(iv interface{}) {
 	switch v := iv.(type) {
 	case nil:
@@ -522,14 +1137,25 @@ func (e *Encoder) encodeValue(rv reflect.Value) {
 	fn, ok := e.f[rtid]
 	if !ok {
 		// debugf("\tCreating new enc fn for type: %v\n", rt)
-		fi := encFnInfo { sis:getTypeInfo(rtid, rt), e:e, ee:e.e, rt:rt, rtid:rtid }
-		if e.e.isBuiltinType(fi.sis.baseId) {
+		fi := encFnInfo { sis:e.h.getTypeInfo(rtid, rt), e:e, ee:e.e, rt:rt, rtid:rtid }
+		if rtid == rawTypId {
+			fn = encFn{ &fi, (*encFnInfo).raw }
+		} else if rtid == rawExtTypId {
+			fn = encFn{ &fi, (*encFnInfo).rawExt }
+		} else if xf, xfIndir := e.h.getExtForType(rt, false); xf != nil {
+			fi.xf, fi.xfIndir = xf, xfIndir
+			fn = encFn{ &fi, (*encFnInfo).ext }
+		} else if e.e.isBuiltinType(fi.sis.baseId) {
 			fn = encFn{ &fi, (*encFnInfo).builtin }
-		} else if xfTag, xfFn := e.h.getEncodeExt(fi.sis.baseId); xfFn != nil {
-			fi.xfTag, fi.xfFn = xfTag, xfFn
+		} else if xf, xfIndir := e.h.getExtForType(rt, true); xf != nil {
+			fi.xf, fi.xfIndir = xf, xfIndir
 			fn = encFn{ &fi, (*encFnInfo).ext }
 		} else if supportBinaryMarshal && fi.sis.m {
 			fn = encFn{ &fi, (*encFnInfo).binaryMarshal }
+		} else if fi.sis.tm {
+			fn = encFn{ &fi, (*encFnInfo).textMarshal }
+		} else if fi.sis.jm && e.h.useJsonMarshaler() {
+			fn = encFn{ &fi, (*encFnInfo).jsonMarshal }
 		} else {
 			switch rk := rt.Kind(); rk {
 			case reflect.Bool:
@@ -555,7 +1181,11 @@ func (e *Encoder) encodeValue(rv reflect.Value) {
 			case reflect.Ptr:
 				fn = encFn{ &fi, (*encFnInfo).kPtr }
 			case reflect.Interface:
-				fn = encFn{ &fi, (*encFnInfo).kInterface }
+				if rt.NumMethod() > 0 && e.h.typeRegistry().forIntf(rtid) != nil {
+					fn = encFn{ &fi, (*encFnInfo).kInterfaceRegistered }
+				} else {
+					fn = encFn{ &fi, (*encFnInfo).kInterface }
+				}
 			case reflect.Map:
 				fn = encFn{ &fi, (*encFnInfo).kMap }
 			default:
@@ -565,8 +1195,10 @@ func (e *Encoder) encodeValue(rv reflect.Value) {
 		e.f[rtid] = fn
 	}
 	
+	rt0 := e.p.rt
+	e.p.rt = rt
 	fn.f(fn.i, rv)
-
+	e.p.rt = rt0
 }
 
 // ----------------------------------------
@@ -11,8 +11,10 @@ import (
 	"fmt"
 	"reflect"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 	"unicode"
 	"unicode/utf8"
@@ -62,12 +64,28 @@ type binaryMarshaler interface {
 	MarshalBinary() (data []byte, err error)
 }
 
+type textUnmarshaler interface {
+	UnmarshalText(text []byte) error
+}
+
+type textMarshaler interface {
+	MarshalText() (text []byte, err error)
+}
+
+type jsonUnmarshaler interface {
+	UnmarshalJSON(data []byte) error
+}
+
+type jsonMarshaler interface {
+	MarshalJSON() (data []byte, err error)
+}
+
 var (
 	bigen               = binary.BigEndian
 	structInfoFieldName = "_struct"
 
-	cachedTypeInfo      = make(map[uintptr]*typeInfo, 4)
-	cachedTypeInfoMutex sync.RWMutex
+	// defTypeInfos is used by Handles which do not configure their own TypeInfos.
+	defTypeInfos = NewTypeInfos([]string{structTagName})
 
 	nilIntfSlice     = []interface{}(nil)
 	intfSliceTyp     = reflect.TypeOf(nilIntfSlice)
@@ -84,12 +102,19 @@ var (
 	timeTypId        = reflect.ValueOf(timeTyp).Pointer()
 	ptrTimeTypId     = reflect.ValueOf(ptrTimeTyp).Pointer()
 	byteSliceTypId   = reflect.ValueOf(byteSliceTyp).Pointer()
+	rawTypId         = reflect.ValueOf(reflect.TypeOf(Raw(nil))).Pointer()
+	rawExtTypId      = reflect.ValueOf(reflect.TypeOf(RawExt{})).Pointer()
 	
 	binaryMarshalerTyp = reflect.TypeOf((*binaryMarshaler)(nil)).Elem()
 	binaryUnmarshalerTyp = reflect.TypeOf((*binaryUnmarshaler)(nil)).Elem()
 	
 	binaryMarshalerTypId = reflect.ValueOf(binaryMarshalerTyp).Pointer()
 	binaryUnmarshalerTypId = reflect.ValueOf(binaryUnmarshalerTyp).Pointer()
+
+	textMarshalerTyp   = reflect.TypeOf((*textMarshaler)(nil)).Elem()
+	textUnmarshalerTyp = reflect.TypeOf((*textUnmarshaler)(nil)).Elem()
+	jsonMarshalerTyp   = reflect.TypeOf((*jsonMarshaler)(nil)).Elem()
+	jsonUnmarshalerTyp = reflect.TypeOf((*jsonUnmarshaler)(nil)).Elem()
 	
 	intBitsize  uint8 = uint8(reflect.TypeOf(int(0)).Bits())
 	uintBitsize uint8 = uint8(reflect.TypeOf(uint(0)).Bits())
@@ -108,6 +133,249 @@ type Handle interface {
 	decodeHandleI
 	newEncDriver(w encWriter) encDriver
 	newDecDriver(r decReader) decDriver
+	// valueSeparator reports whether values written one after the other need a
+	// separator, as in text formats (e.g. json) where a top-level number is only
+	// terminated by the next character in the stream.
+	valueSeparator() bool
+}
+
+// Raw holds the encoded bytes of a single value, in the format of the Handle used
+// (like encoding/json.RawMessage). It allows deferring the decoding of a value, 
+// e.g. until its type is known from another value.
+// 
+// When decoding into a Raw, the bytes of the next value are stored without being interpreted.
+// When encoding a Raw, its bytes are written verbatim.
+// A nil in the stream is decoded as a nil Raw, and a nil or empty Raw is encoded as nil.
+// 
+// For binc, the symbols in a Raw value are written as strings, as they are only 
+// meaningful within the stream they were read from.
+type Raw []byte
+
+// RawExt holds an extension value (its tag and payload) as found in the stream.
+// 
+// Schema-less decoding (into a nil interface{}) produces a RawExt for extension tags 
+// which have no registered type, and decoding into a RawExt always keeps the extension 
+// as is. When encoding a RawExt, it is written back unchanged as an extension.
+// 
+// For formats (or options) which do not write extension tags (e.g. json, or msgpack 
+// without WriteExt), only the Data is written, like other extensions (e.g. as base64 
+// in json). Decoding it into a RawExt keeps the Tag the RawExt already had.
+type RawExt struct {
+	Tag  byte
+	Data []byte
+}
+
+// BasicHandle holds the options common to all Handles, 
+// used when both encoding and decoding.
+type BasicHandle struct {
+	// TypeInfos configures how the fields of a struct are named (see NewTypeInfos).
+	// If nil, the "codec" key of the struct tag is used.
+	TypeInfos *TypeInfos
+
+	// UseJsonMarshaler enables encoding and decoding values using their MarshalJSON
+	// and UnmarshalJSON methods (i.e. json.Marshaler and json.Unmarshaler), if no
+	// extension is registered for them, and they do not implement BinaryMarshaler or
+	// TextMarshaler (or BinaryUnmarshaler or TextUnmarshaler respectively).
+	//
+	// The JSON is embedded as a string, except for JsonHandle where it is written as is.
+	UseJsonMarshaler bool
+
+	reg typeRegistry
+}
+
+// RegisterType registers the concrete type of v under a name, so that values of
+// the interface type iface (with methods), which it implements, can be encoded and decoded.
+// 
+// A value of an interface type with registered types is encoded as an array of 2 elements: 
+// the name its concrete type is registered under, and the value itself. When decoding, 
+// the name is used to create a value of the registered type. It is an error to encode 
+// a value of an unregistered type in it. Other interface types (even if implemented by 
+// a registered type) are not affected.
+// 
+// Note that v can be a pointer (e.g. if only the pointer type implements the interface).
+func (x *BasicHandle) RegisterType(iface reflect.Type, name string, v interface{}) error {
+	return x.reg.add(iface, name, v)
+}
+
+// RegisterTypeTag is like RegisterType, but the type is identified in the stream 
+// by a number (e.g. an extension tag) instead of a name.
+func (x *BasicHandle) RegisterTypeTag(iface reflect.Type, tag uint64, v interface{}) error {
+	return x.reg.add(iface, tag, v)
+}
+
+func (x *BasicHandle) useJsonMarshaler() bool {
+	return x.UseJsonMarshaler
+}
+
+func (x *BasicHandle) typeRegistry() *typeRegistry {
+	return &x.reg
+}
+
+// typeRegistry holds the registered types of each interface type (by its rtid).
+type typeRegistry struct {
+	intfs map[uintptr]*typeRegistryIntf
+}
+
+// typeRegistryIntf maps the concrete types registered for an interface type 
+// to the marker (a string or uint64) identifying them in the stream.
+type typeRegistryIntf struct {
+	types   map[uintptr]interface{}
+	markers map[interface{}]reflect.Type
+}
+
+func (x *typeRegistry) add(iface reflect.Type, marker interface{}, v interface{}) (err error) {
+	if iface == nil || iface.Kind() != reflect.Interface || iface.NumMethod() == 0 {
+		return fmt.Errorf("codec.Handle.RegisterType: Expecting interface type with methods. Got: %v", iface)
+	}
+	rt := reflect.TypeOf(v)
+	if rt == nil {
+		return errors.New("codec.Handle.RegisterType: Cannot register nil")
+	}
+	if !rt.Implements(iface) {
+		return fmt.Errorf("codec.Handle.RegisterType: Type %v does not implement %v", rt, iface)
+	}
+	irtid := reflect.ValueOf(iface).Pointer()
+	xi := x.intfs[irtid]
+	if xi == nil {
+		xi = &typeRegistryIntf{
+			types:   make(map[uintptr]interface{}, 4),
+			markers: make(map[interface{}]reflect.Type, 4),
+		}
+	}
+	rtid := reflect.ValueOf(rt).Pointer()
+	if m, ok := xi.types[rtid]; ok {
+		return fmt.Errorf("codec.Handle.RegisterType: Type %v already registered as %v for %v", rt, m, iface)
+	}
+	if rt2, ok := xi.markers[marker]; ok {
+		return fmt.Errorf("codec.Handle.RegisterType: %v already registered for type %v in %v", marker, rt2, iface)
+	}
+	if x.intfs == nil {
+		x.intfs = make(map[uintptr]*typeRegistryIntf, 4)
+	}
+	x.intfs[irtid] = xi
+	xi.types[rtid] = marker
+	xi.markers[marker] = rt
+	return
+}
+
+// forIntf returns the types registered for the interface type with the given rtid,
+// or nil if there are none.
+func (x *typeRegistry) forIntf(rtid uintptr) *typeRegistryIntf {
+	return x.intfs[rtid]
+}
+
+func (x *BasicHandle) getTypeInfo(rtid uintptr, rt reflect.Type) *typeInfo {
+	if x.TypeInfos == nil {
+		return defTypeInfos.get(rtid, rt)
+	}
+	return x.TypeInfos.get(rtid, rt)
+}
+
+// TypeInfos caches information about the types seen during encoding and decoding,
+// for a given configuration of which struct tag keys are consulted for the name and 
+// options (e.g. omitempty) of a struct field.
+// 
+// Create one using NewTypeInfos, and share it across Handles with the same configuration.
+type TypeInfos struct {
+	tags   []string
+	naming func(string) string
+	// infos holds a map[uintptr]*typeInfo which is never modified once stored.
+	// Lookups do not need a lock. To add to it, a copy is made and stored 
+	// while holding mu (so concurrent additions are not lost).
+	infos atomic.Value
+	mu    sync.Mutex
+}
+
+// NewTypeInfos returns a TypeInfos which consults the given keys of a struct field's tag, 
+// in order, using the first which is set. 
+// 
+// For example, with tags []string{"codec", "json"}, the json tag of a field is used
+// (including its "-" and omitempty options) if it has no codec tag.
+func NewTypeInfos(tags []string) *TypeInfos {
+	return NewTypeInfosNaming(tags, nil)
+}
+
+// NewTypeInfosNaming is like NewTypeInfos, but the name of a struct field whose tag
+// does not give a name is naming(fieldName), instead of the field name itself. 
+// 
+// naming can be a builtin policy (SnakeCase, LowerCamelCase, KebabCase) or any func.
+func NewTypeInfosNaming(tags []string, naming func(fieldName string) string) *TypeInfos {
+	return &TypeInfos{tags: append([]string(nil), tags...), naming: naming}
+}
+
+// SnakeCase names a field in snake_case, e.g. UserID becomes user_id.
+func SnakeCase(fieldName string) string {
+	return strings.ToLower(strings.Join(splitFieldName(fieldName), "_"))
+}
+
+// KebabCase names a field in kebab-case, e.g. UserID becomes user-id.
+func KebabCase(fieldName string) string {
+	return strings.ToLower(strings.Join(splitFieldName(fieldName), "-"))
+}
+
+// LowerCamelCase names a field in lowerCamelCase, e.g. UserID becomes userID, 
+// and HTTPServer becomes httpServer.
+func LowerCamelCase(fieldName string) string {
+	words := splitFieldName(fieldName)
+	if len(words) == 0 {
+		return fieldName
+	}
+	words[0] = strings.ToLower(words[0])
+	return strings.Join(words, "")
+}
+
+// splitFieldName splits a (camel case) Go field name into words.
+// A word starts at an upper case letter following a lower case letter or digit,
+// or at the last upper case letter of an acronym followed by a lower case letter.
+// E.g. HTTPServer2Addr is split into HTTP, Server2, Addr.
+func splitFieldName(s string) (words []string) {
+	rs := []rune(s)
+	start := 0
+	for i := 1; i < len(rs); i++ {
+		if !unicode.IsUpper(rs[i]) {
+			continue
+		}
+		if !unicode.IsUpper(rs[i-1]) || (i+1 < len(rs) && unicode.IsLower(rs[i+1])) {
+			words = append(words, string(rs[start:i]))
+			start = i
+		}
+	}
+	if start < len(rs) {
+		words = append(words, string(rs[start:]))
+	}
+	return
+}
+
+// structTag returns the value of the first of the configured keys set in the struct tag.
+func (x *TypeInfos) structTag(t reflect.StructTag) (s string) {
+	for _, k := range x.tags {
+		if s = t.Get(k); s != "" {
+			return
+		}
+	}
+	return
+}
+
+// BytesExt handles custom encoding of a type to and from the bytes of
+// an extension payload.
+type BytesExt interface {
+	// WriteExt returns the bytes of the extension payload for v.
+	WriteExt(v interface{}) ([]byte, error)
+	// ReadExt updates dst (a pointer to the extension type) from the bytes
+	// of the extension payload.
+	ReadExt(dst interface{}, src []byte) error
+}
+
+// InterfaceExt handles custom encoding of a type by converting it to and from an
+// intermediate value (e.g. a slice or map), which is encoded natively as the
+// extension payload. If the Handle does not write extensions (e.g. JsonHandle, or
+// MsgpackHandle without WriteExt), the intermediate value is encoded in place.
+type InterfaceExt interface {
+	// ConvertExt returns the intermediate value to encode in place of v.
+	ConvertExt(v interface{}) (interface{}, error)
+	// UpdateExt updates dst (a pointer to the extension type) from src,
+	// the intermediate value as decoded without a schema (e.g. []interface{}).
+	UpdateExt(dst interface{}, src interface{}) error
 }
 
 type extTypeTagFn struct {
@@ -116,79 +384,199 @@ type extTypeTagFn struct {
 	tag byte
 	encFn func(reflect.Value) ([]byte, error)
 	decFn func(reflect.Value, []byte) error
+	ext InterfaceExt
 }
 
-type extHandle map[uintptr]*extTypeTagFn
+// encode returns the bytes of the extension payload for rv.
+func (x *extTypeTagFn) encode(h Handle, rv reflect.Value) (bs []byte, err error) {
+	if x.ext == nil {
+		return x.encFn(rv)
+	}
+	v, err := x.ext.ConvertExt(rv.Interface())
+	if err != nil || v == nil {
+		return
+	}
+	err = NewEncoderBytes(&bs, h).Encode(v)
+	return
+}
+
+// decode updates rv from the bytes of the extension payload.
+func (x *extTypeTagFn) decode(h Handle, rv reflect.Value, bs []byte) (err error) {
+	if x.ext == nil {
+		return x.decFn(rv, bs)
+	}
+	var v interface{}
+	if err = NewDecoderBytes(bs, h).Decode(&v); err != nil {
+		return
+	}
+	return x.ext.UpdateExt(rv.Addr().Interface(), v)
+}
+
+// extHandle holds the registered extensions, indexed by type and by tag.
+// Extensions registered for interface types are also kept in registration order.
+type extHandle struct {
+	rtids map[uintptr]*extTypeTagFn
+	tags  map[byte]*extTypeTagFn
+	intfs []*extTypeTagFn
+}
 
 // AddExt registers an encode and decode function for a reflect.Type.
-// Note that the type must be a named type, and specifically not 
-// a pointer or Interface. An error is returned if that is not honored.
+// Note that the tag must not already be registered for another type. 
+// An error is returned if that is not honored.
+//
+// The type can be named or unnamed (e.g. []int), a pointer (e.g. *big.Int), 
+// or an interface (e.g. error). When encoding or decoding a value of type T, 
+// the first match below is used:
+//   - an extension registered for T, or for the type T points to 
+//     (and so on, for each pointer level down to the base type)
+//   - the builtin support of the format (e.g. time.Time)
+//   - an extension registered for an interface which T (or a type T points to)
+//     implements, in the order the extensions were registered
+//   - BinaryMarshaler, TextMarshaler (and json.Marshaler if UseJsonMarshaler is set)
+//   - the default support for the kind of T
+// 
+// The functions are passed the value at the matched level (e.g. a *big.Int, if
+// registered for *big.Int). An extension registered for an interface type is also 
+// used to decode into a nil value of that interface type.
+//
+// Passing nil functions removes the registration (see RemoveExt).
 func (o *extHandle) AddExt(
 	rt reflect.Type,
 	tag byte,
 	encfn func(reflect.Value) ([]byte, error),
 	decfn func(reflect.Value, []byte) error,
 ) (err error) {
+	if encfn == nil || decfn == nil {
+		return o.setExt("AddExt", rt, nil)
+	}
+	return o.setExt("AddExt", rt, &extTypeTagFn { tag: tag, encFn: encfn, decFn: decfn })
+}
+
+// SetExt registers an extension for a reflect.Type, which must implement either
+// BytesExt or InterfaceExt (checked in that order). A nil ext removes the registration.
+// Like AddExt, the tag must not already be registered for another type, and the
+// same rules apply for matching types.
+func (o *extHandle) SetExt(rt reflect.Type, tag byte, ext interface{}) (err error) {
+	switch x := ext.(type) {
+	case nil:
+		return o.setExt("SetExt", rt, nil)
+	case BytesExt:
+		return o.setExt("SetExt", rt, &extTypeTagFn { 
+			tag: tag,
+			encFn: func(rv reflect.Value) ([]byte, error) { return x.WriteExt(rv.Interface()) },
+			decFn: func(rv reflect.Value, bs []byte) error { return x.ReadExt(rv.Addr().Interface(), bs) },
+		})
+	case InterfaceExt:
+		return o.setExt("SetExt", rt, &extTypeTagFn { tag: tag, ext: x })
+	}
+	return fmt.Errorf("codec.Handle.SetExt: Extension must implement BytesExt or InterfaceExt: %T", ext)
+}
+
+func (o *extHandle) setExt(fnName string, rt reflect.Type, x *extTypeTagFn) (err error) {
 	// o is a pointer, because we may need to initialize it
-	if rt.PkgPath() == "" || rt.Kind() == reflect.Interface {
-		err = fmt.Errorf("codec.Handle.AddExt: Takes a named type, especially not a pointer or interface: %T", 
-			reflect.Zero(rt).Interface())
+	if o == nil || rt == nil {
+		err = fmt.Errorf("codec.Handle.%s: Nil (should never happen)", fnName)
 		return
 	}
-	if o == nil {
-		err = errors.New("codec.Handle.AddExt: Nil (should never happen)")
+	rtid := reflect.ValueOf(rt).Pointer()
+	if x != nil {
+		if x2 := o.tags[x.tag]; x2 != nil && x2.rtid != rtid {
+			err = fmt.Errorf("codec.Handle.%s: Tag %v already registered for type %v, cannot register it for %v",
+				fnName, x.tag, x2.rt, rt)
+			return
+		}
+	}
+	o.RemoveExt(rt)
+	if x == nil {
 		return
 	}
-	rtid := reflect.ValueOf(rt).Pointer()
-	if *o == nil {
-		*o = make(map[uintptr]*extTypeTagFn, 4)
+	if o.rtids == nil {
+		o.rtids = make(map[uintptr]*extTypeTagFn, 4)
+		o.tags = make(map[byte]*extTypeTagFn, 4)
 	}
-	m := *o
-	if encfn == nil || decfn == nil {
-		delete(m, rtid)
-	} else {
-		m[rtid] = &extTypeTagFn { rtid, rt, tag, encfn, decfn }
+	x.rtid, x.rt = rtid, rt
+	o.rtids[rtid] = x
+	o.tags[x.tag] = x
+	if rt.Kind() == reflect.Interface {
+		o.intfs = append(o.intfs, x)
 	}
 	return
 }
 
-func (o extHandle) getExt(rtid uintptr) *extTypeTagFn {
-	return o[rtid]
+// RemoveExt removes the extension registered for a reflect.Type (if any),
+// freeing up its tag.
+func (o *extHandle) RemoveExt(rt reflect.Type) {
+	rtid := reflect.ValueOf(rt).Pointer()
+	if x := o.rtids[rtid]; x != nil {
+		delete(o.rtids, rtid)
+		delete(o.tags, x.tag)
+		for i, x2 := range o.intfs {
+			if x2 == x {
+				o.intfs = append(o.intfs[:i], o.intfs[i+1:]...)
+				break
+			}
+		}
+	}
+}
+
+func (o *extHandle) getExt(rtid uintptr) *extTypeTagFn {
+	return o.rtids[rtid]
 }
 
-func (o extHandle) getExtForTag(tag byte) *extTypeTagFn {
-	for _, v := range o {
-		if v.tag == tag {
-			return v
+// getExtForType returns the extension registered for rt (or for an interface it
+// implements, if intf is set), walking down its pointer levels, and the number
+// of indirections to the matched type.
+func (o *extHandle) getExtForType(rt reflect.Type, intf bool) (x *extTypeTagFn, indir int8) {
+	if intf && len(o.intfs) == 0 {
+		return
+	}
+	for {
+		if intf {
+			for _, x = range o.intfs {
+				if rt.Implements(x.rt) {
+					return
+				}
+			}
+			x = nil
+		} else if x = o.rtids[reflect.ValueOf(rt).Pointer()]; x != nil {
+			return
+		}
+		if rt.Kind() != reflect.Ptr {
+			return
 		}
+		rt = rt.Elem()
+		indir++
 	}
-	return nil
 }
 
-func (o extHandle) getDecodeExtForTag(tag byte) (
-	rv reflect.Value, fn func(reflect.Value, []byte) error) {
-	if x := o.getExtForTag(tag); x != nil {
+func (o *extHandle) getExtForTag(tag byte) *extTypeTagFn {
+	return o.tags[tag]
+}
+
+func (o *extHandle) getDecodeExtForTag(tag byte) (rv reflect.Value, x *extTypeTagFn) {
+	if x = o.getExtForTag(tag); x != nil {
 		// ext is only registered for base
 		rv = reflect.New(x.rt).Elem()
-		fn = x.decFn
 	}
 	return
 }
 
-func (o extHandle) getDecodeExt(rtid uintptr) (tag byte, fn func(reflect.Value, []byte) error) {
-	if x, ok := o[rtid]; ok {
-		tag = x.tag
-		fn = x.decFn
+// indirIntf returns the value (as an interface{}) which implements an interface,
+// given the number of indirections from rv to it (as returned by implementsIntf).
+// An indir of -1 means that a pointer to rv implements it.
+func indirIntf(rv reflect.Value, indir int8) interface{} {
+	if indir == -1 {
+		if !rv.CanAddr() {
+			rv2 := reflect.New(rv.Type()).Elem()
+			rv2.Set(rv)
+			rv = rv2
+		}
+		return rv.Addr().Interface()
 	}
-	return
-}
-
-func (o extHandle) getEncodeExt(rtid uintptr) (tag byte, fn func(reflect.Value) ([]byte, error)) {
-	if x, ok := o[rtid]; ok {
-		tag = x.tag
-		fn = x.encFn
+	for j := int8(0); j < indir; j++ {
+		rv = rv.Elem()
 	}
-	return
+	return rv.Interface()
 }
 
 // typeInfo keeps information about each type referenced in the encode/decode sequence.
@@ -211,11 +599,22 @@ type typeInfo struct {
 	unm       bool // base type (T or *T) is a binaryUnmarshaler
 	mIndir    int8 // number of indirections to get to binaryMarshaler type
 	unmIndir  int8 // number of indirections to get to binaryUnmarshaler type
+	tm        bool // base type (T or *T) is a textMarshaler
+	tunm      bool // base type (T or *T) is a textUnmarshaler
+	tmIndir   int8 // number of indirections to get to textMarshaler type
+	tunmIndir int8 // number of indirections to get to textUnmarshaler type
+	jm        bool // base type (T or *T) is a jsonMarshaler
+	junm      bool // base type (T or *T) is a jsonUnmarshaler
+	jmIndir   int8 // number of indirections to get to jsonMarshaler type
+	junmIndir int8 // number of indirections to get to jsonUnmarshaler type
 	toArray   bool // whether this (struct) type should be encoded as an array
+	aliases   map[string]int // index in sis of the field for each alias (see alias tag option)
+	unknown   *structFieldInfo // the field with the unknown tag option (if any)
 }
 
 type structFieldInfo struct {
 	encName   string // encode name
+	name      string // field name
 	
 	// only one of 'i' or 'is' can be set. If 'i' is -1, then 'is' has been set.
 	
@@ -223,6 +622,8 @@ type structFieldInfo struct {
 	i         int16 // field index in struct
 	omitEmpty bool  
 	toArray   bool  // if field is _struct, is the toArray set?
+	aliases   []string // other names matched when decoding
+	unknown   bool     // field holds the keys (and values) not matching other fields
 	
 	// tag       string   // tag
 	// name      string   // field name
@@ -230,6 +631,22 @@ type structFieldInfo struct {
 	// ikind     int      // kind of the field as an int i.e. int(reflect.Kind)
 }
 
+// index returns the (possibly recursive) index of the field in its struct.
+func (si *structFieldInfo) index() []int {
+	if si.i != -1 {
+		return []int{int(si.i)}
+	}
+	return si.is
+}
+
+// field returns the field in struct value rv.
+func (si *structFieldInfo) field(rv reflect.Value) reflect.Value {
+	if si.i != -1 {
+		return rv.Field(int(si.i))
+	}
+	return rv.FieldByIndex(si.is)
+}
+
 type sfiSortedByEncName []*structFieldInfo
 
 func (p sfiSortedByEncName) Len() int           { return len(p) }
@@ -266,21 +683,38 @@ func (sis *typeInfo) indexForEncName(name string) int {
 			return i
 		}
 	}
+	if k, ok := sis.aliases[name]; ok {
+		return k
+	}
+	return -1
+}
+
+// indexForEncNameFold is like indexForEncName, but matches name ignoring case.
+func (sis *typeInfo) indexForEncNameFold(name string) int {
+	for i, si := range sis.sis {
+		if strings.EqualFold(si.encName, name) {
+			return i
+		}
+		for _, a := range si.aliases {
+			if strings.EqualFold(a, name) {
+				return i
+			}
+		}
+	}
 	return -1
 }
 
-func getTypeInfo(rtid uintptr, rt reflect.Type) (sis *typeInfo) {
+func (x *TypeInfos) get(rtid uintptr, rt reflect.Type) (sis *typeInfo) {
 	var ok bool
-	cachedTypeInfoMutex.RLock()
-	sis, ok = cachedTypeInfo[rtid]
-	cachedTypeInfoMutex.RUnlock()
-	if ok {
+	cached, _ := x.infos.Load().(map[uintptr]*typeInfo)
+	if sis, ok = cached[rtid]; ok {
 		return
 	}
 
-	cachedTypeInfoMutex.Lock()
-	defer cachedTypeInfoMutex.Unlock()
-	if sis, ok = cachedTypeInfo[rtid]; ok {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	cached, _ = x.infos.Load().(map[uintptr]*typeInfo)
+	if sis, ok = cached[rtid]; ok {
 		return
 	}
 
@@ -293,6 +727,18 @@ func getTypeInfo(rtid uintptr, rt reflect.Type) (sis *typeInfo) {
 	if ok, indir = implementsIntf(rt, binaryUnmarshalerTyp); ok {
 		sis.unm, sis.unmIndir = true, indir
 	}
+	if ok, indir = implementsIntf(rt, textMarshalerTyp); ok {
+		sis.tm, sis.tmIndir = true, indir
+	}
+	if ok, indir = implementsIntf(rt, textUnmarshalerTyp); ok {
+		sis.tunm, sis.tunmIndir = true, indir
+	}
+	if ok, indir = implementsIntf(rt, jsonMarshalerTyp); ok {
+		sis.jm, sis.jmIndir = true, indir
+	}
+	if ok, indir = implementsIntf(rt, jsonUnmarshalerTyp); ok {
+		sis.junm, sis.junmIndir = true, indir
+	}
 	
 	pt := rt
 	var ptIndir int8 
@@ -309,11 +755,30 @@ func getTypeInfo(rtid uintptr, rt reflect.Type) (sis *typeInfo) {
 	if rt.Kind() == reflect.Struct {
 		var siInfo *structFieldInfo
 		if f, ok := rt.FieldByName(structInfoFieldName); ok {
-			siInfo = parseStructFieldInfo(structInfoFieldName, f.Tag.Get(structTagName))
+			siInfo = parseStructFieldInfo(structInfoFieldName, x.structTag(f.Tag))
 			sis.toArray = siInfo.toArray
 		}
 		sisp := make([]*structFieldInfo, 0, rt.NumField())
-		rgetTypeInfo(rt, nil, make(map[string]bool), &sisp, siInfo)
+		x.rget(rt, nil, make(map[string]bool), &sisp, siInfo)
+		for i := 0; i < len(sisp); i++ {
+			if !sisp[i].unknown {
+				continue
+			}
+			if sis.unknown != nil {
+				doPanic("codec", "Only one field of %v can have the unknown tag option", rt)
+			}
+			if ft := rt.FieldByIndex(sisp[i].index()).Type; ft.Kind() != reflect.Map || ft.Key().Kind() != reflect.String {
+				doPanic("codec", "Field %v.%v with the unknown tag option must be a map with string keys. Got: %v", 
+					rt, sisp[i].name, ft)
+			}
+			if sis.toArray {
+				doPanic("codec", "Field %v.%v with the unknown tag option cannot be used in a toarray struct", 
+					rt, sisp[i].name)
+			}
+			sis.unknown = sisp[i]
+			sisp = append(sisp[:i], sisp[i+1:]...)
+			i--
+		}
 
 		// // try to put all si close together
 		// const tryToPutAllStructFieldInfoTogether = true
@@ -332,18 +797,31 @@ func getTypeInfo(rtid uintptr, rt reflect.Type) (sis *typeInfo) {
 		copy(sis.sisp, sisp)
 		sort.Sort(sfiSortedByEncName(sisp))
 		copy(sis.sis, sisp)
+		for i, si := range sis.sis {
+			for _, a := range si.aliases {
+				if sis.aliases == nil {
+					sis.aliases = make(map[string]int)
+				}
+				sis.aliases[a] = i
+			}
+		}
 	}
 	// sis = sisp
-	cachedTypeInfo[rtid] = sis
+	cached2 := make(map[uintptr]*typeInfo, len(cached)+1)
+	for k, v := range cached {
+		cached2[k] = v
+	}
+	cached2[rtid] = sis
+	x.infos.Store(cached2)
 	return
 }
 
-func rgetTypeInfo(rt reflect.Type, indexstack []int, fnameToHastag map[string]bool,
+func (x *TypeInfos) rget(rt reflect.Type, indexstack []int, fnameToHastag map[string]bool,
 	sis *[]*structFieldInfo, siInfo *structFieldInfo,
 ) {
 	for j := 0; j < rt.NumField(); j++ {
 		f := rt.Field(j)
-		stag := f.Tag.Get(structTagName)
+		stag := x.structTag(f.Tag)
 		if stag == "-" {
 			continue
 		}
@@ -354,7 +832,7 @@ func rgetTypeInfo(rt reflect.Type, indexstack []int, fnameToHastag map[string]bo
 			//if anonymous, inline it if there is no struct tag, else treat as regular field
 			if stag == "" {
 				indexstack2 := append(append([]int(nil), indexstack...), j)
-				rgetTypeInfo(f.Type, indexstack2, fnameToHastag, sis, siInfo)
+				x.rget(f.Type, indexstack2, fnameToHastag, sis, siInfo)
 				continue
 			}
 		}
@@ -365,6 +843,9 @@ func rgetTypeInfo(rt reflect.Type, indexstack []int, fnameToHastag map[string]bo
 			continue
 		}
 		si := parseStructFieldInfo(f.Name, stag)
+		if x.naming != nil && (stag == "" || stag[0] == ',') {
+			si.encName = x.naming(f.Name)
+		}
 		// si.ikind = int(f.Type.Kind())
 		if len(indexstack) == 0 {
 			si.i = int16(j)
@@ -388,7 +869,7 @@ func parseStructFieldInfo(fname string, stag string) *structFieldInfo {
 		panic("parseStructFieldInfo: No Field Name")
 	}
 	si := structFieldInfo{
-		// name: fname,
+		name: fname,
 		encName: fname,
 		// tag: stag,
 	}
@@ -405,6 +886,12 @@ func parseStructFieldInfo(fname string, stag string) *structFieldInfo {
 					si.omitEmpty = true
 				case "toarray":
 					si.toArray = true
+				case "unknown":
+					si.unknown = true
+				default:
+					if strings.HasPrefix(s, "alias=") {
+						si.aliases = strings.Split(s[len("alias="):], "|")
+					}
 				}
 			}
 		}
@@ -413,6 +900,102 @@ func parseStructFieldInfo(fname string, stag string) *structFieldInfo {
 	return &si
 }
 
+// pathElem is an element of the path to a value: a struct field name,
+// an array/slice index or a map key.
+type pathElem struct {
+	name string
+	i    int
+	k    reflect.Value
+}
+
+// valuePath tracks the path to (and type of) the value currently being
+// encoded or decoded, so an error can report where it happened.
+//
+// Elements are pushed and popped as containers are walked. When a panic
+// unwinds the stack, they are not popped, and so hold the path at the error.
+type valuePath struct {
+	root reflect.Type // type of the top-level value
+	rt   reflect.Type // type of the value currently being encoded or decoded
+	p    []pathElem
+}
+
+func (x *valuePath) reset(root reflect.Type) {
+	for root != nil && root.Kind() == reflect.Ptr {
+		root = root.Elem()
+	}
+	x.root, x.rt, x.p = root, root, x.p[:0]
+}
+
+func (x *valuePath) pushName(name string) {
+	x.p = append(x.p, pathElem{name: name})
+}
+
+func (x *valuePath) pushIndex(i int) {
+	x.p = append(x.p, pathElem{i: i})
+}
+
+func (x *valuePath) pushKey(k reflect.Value) {
+	x.p = append(x.p, pathElem{k: k})
+}
+
+// setIndex updates the index of the last element (pushed with pushIndex).
+func (x *valuePath) setIndex(i int) {
+	x.p[len(x.p)-1].i = i
+}
+
+func (x *valuePath) pop() {
+	x.p = x.p[:len(x.p)-1]
+}
+
+// String returns the path in go syntax, e.g. Outer.Items[3].Name
+func (x *valuePath) String() string {
+	var buf []byte
+	if x.root != nil {
+		buf = append(buf, x.root.Name()...)
+	}
+	for _, pe := range x.p {
+		switch {
+		case pe.name != "":
+			if len(buf) > 0 {
+				buf = append(buf, '.')
+			}
+			buf = append(buf, pe.name...)
+		case pe.k.IsValid():
+			k := pe.k
+			for k.Kind() == reflect.Interface && !k.IsNil() {
+				k = k.Elem()
+			}
+			if k.Kind() == reflect.String {
+				buf = strconv.AppendQuote(append(buf, '['), k.String())
+				buf = append(buf, ']')
+			} else if k.CanInterface() {
+				buf = append(buf, fmt.Sprintf("[%v]", k.Interface())...)
+			} else {
+				buf = append(buf, "[?]"...)
+			}
+		default:
+			buf = strconv.AppendInt(append(buf, '['), int64(pe.i), 10)
+			buf = append(buf, ']')
+		}
+	}
+	return string(buf)
+}
+
+// errContext describes where an error happened (e.g. "path: A.B, type: int"),
+// for use in error messages.
+func errContext(path string, rt reflect.Type) (s string) {
+	if path != "" {
+		s = "path: " + path
+	}
+	if rt != nil {
+		if s != "" {
+			s += ", "
+		}
+		s += "type: " + rt.String()
+	}
+	return
+}
+
 func panicToErr(err *error) {
 	if x := recover(); x != nil {
 		//debug.PrintStack()
@@ -0,0 +1,861 @@
+// Copyright (c) 2012, 2013 Ugorji Nwoke. All rights reserved.
+// Use of this source code is governed by a BSD-style license found in the LICENSE file.
+
+package codec
+
+import (
+	"encoding/base64"
+	"math"
+	"reflect"
+	"strconv"
+	"time"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// JsonHandle is a Handle for JSON encoding format, as defined at http://tools.ietf.org/html/rfc7159 .
+//
+// It uses the same struct tags, extensions and options as the other handles:
+//   - Raw bytes ([]byte) are encoded as base64 strings.
+//   - time.Time is encoded as a string in RFC3339Nano format.
+//   - Registered extensions are encoded as base64 strings of the extension bytes.
+//     (JSON has no way to mark a value with an extension tag).
+//   - Map keys which are numbers or bools are encoded as quoted strings,
+//     and are decoded back from quoted strings.
+//
+// When decoding into a nil interface{}, numbers are decoded as int64 (if negative),
+// uint64 (if non-negative), or float64 (if they have a fraction or exponent).
+// JSON objects are decoded into a map[string]interface{}, unless MapType is set.
+type JsonHandle struct {
+	BasicHandle
+	extHandle
+	EncodeOptions
+	DecodeOptions
+}
+
+type jsonEncDriver struct {
+	w encWriter
+	h *JsonHandle
+	k bool // next value written is a map key
+	b [64]byte
+}
+
+type jsonDecDriver struct {
+	r      decReader
+	h      *JsonHandle
+	bdRead bool
+	bdType decodeEncodedType
+	bd     byte
+	c      byte   // byte read ahead (e.g. at end of a number), to be returned by next readn1
+	cr     bool   // c is set
+	s      []byte // scratch buffer for strings and numbers
+	ct     []byte // stack of currently open containers ('[' or '{')
+}
+
+func (h *JsonHandle) newEncDriver(w encWriter) encDriver {
+	return &jsonEncDriver{w: w, h: h}
+}
+
+func (h *JsonHandle) newDecDriver(r decReader) decDriver {
+	return &jsonDecDriver{r: r, h: h}
+}
+
+func (_ *JsonHandle) valueSeparator() bool {
+	return true
+}
+
+// nativeJson reports that JSON (e.g. from MarshalJSON) is written into the stream as is.
+func (_ *JsonHandle) nativeJson() bool {
+	return true
+}
+
+func (_ *JsonHandle) writeExt() bool {
+	return false
+}
+
+// ---------------------------------------------
+
+func (e *jsonEncDriver) isBuiltinType(rt uintptr) bool {
+	return rt == timeTypId
+}
+
+func (e *jsonEncDriver) encodeBuiltinType(rt uintptr, rv reflect.Value) {
+	switch rt {
+	case timeTypId:
+		e.w.writen1('"')
+		e.w.writeb(rv.Interface().(time.Time).AppendFormat(e.b[:0], time.RFC3339Nano))
+		e.w.writen1('"')
+	}
+}
+
+func (e *jsonEncDriver) encodeNil() {
+	if e.k {
+		encErr("json: map key cannot be nil")
+	}
+	e.w.writestr("null")
+}
+
+func (e *jsonEncDriver) encodeBool(b bool) {
+	var s string
+	if b {
+		s = "true"
+	} else {
+		s = "false"
+	}
+	e.writeScalar([]byte(s))
+}
+
+func (e *jsonEncDriver) encodeInt(i int64) {
+	e.writeScalar(strconv.AppendInt(e.b[:0], i, 10))
+}
+
+func (e *jsonEncDriver) encodeUint(i uint64) {
+	e.writeScalar(strconv.AppendUint(e.b[:0], i, 10))
+}
+
+func (e *jsonEncDriver) encodeFloat32(f float32) {
+	e.encodeFloat(float64(f), 32)
+}
+
+func (e *jsonEncDriver) encodeFloat64(f float64) {
+	e.encodeFloat(f, 64)
+}
+
+func (e *jsonEncDriver) encodeFloat(f float64, bitsize int) {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		encErr("json: unsupported float value: %v", f)
+	}
+	bs := strconv.AppendFloat(e.b[:0], f, 'g', -1, bitsize)
+	// ensure that a float is always decoded back as a float (e.g. 1.0 not 1)
+	isInt := true
+	for _, c := range bs {
+		if c == '.' || c == 'e' {
+			isInt = false
+			break
+		}
+	}
+	if isInt {
+		bs = append(bs, '.', '0')
+	}
+	e.writeScalar(bs)
+}
+
+// writeScalar writes a number or bool, quoting it if it is used as a map key.
+func (e *jsonEncDriver) writeScalar(bs []byte) {
+	if e.k {
+		e.w.writen1('"')
+		e.w.writeb(bs)
+		e.w.writen1('"')
+	} else {
+		e.w.writeb(bs)
+	}
+}
+
+func (e *jsonEncDriver) encodeExtPreamble(xtag byte, length int) {
+	encErr("json: extension tags are not supported")
+}
+
+func (e *jsonEncDriver) encodeArrayPreamble(length int) {
+	if e.k {
+		encErr("json: map key cannot be an array")
+	}
+	e.w.writen1('[')
+}
+
+func (e *jsonEncDriver) encodeArrayEntrySeparator() {
+	e.w.writen1(',')
+}
+
+func (e *jsonEncDriver) encodeArrayEnd() {
+	e.w.writen1(']')
+}
+
+func (e *jsonEncDriver) encodeMapPreamble(length int) {
+	if e.k {
+		encErr("json: map key cannot be a map")
+	}
+	e.w.writen1('{')
+	e.k = true
+}
+
+func (e *jsonEncDriver) encodeMapEntrySeparator() {
+	e.w.writen1(',')
+	e.k = true
+}
+
+func (e *jsonEncDriver) encodeMapKVSeparator() {
+	e.w.writen1(':')
+	e.k = false
+}
+
+func (e *jsonEncDriver) encodeMapEnd() {
+	e.w.writen1('}')
+	e.k = false
+}
+
+func (e *jsonEncDriver) encodeString(c charEncoding, v string) {
+	if c == c_RAW {
+		e.encodeBase64([]byte(v))
+		return
+	}
+	e.quoteStr(v)
+}
+
+func (e *jsonEncDriver) encodeSymbol(v string) {
+	e.quoteStr(v)
+}
+
+func (e *jsonEncDriver) encodeStringBytes(c charEncoding, v []byte) {
+	if c == c_RAW {
+		e.encodeBase64(v)
+		return
+	}
+	e.quoteStr(string(v))
+}
+
+func (e *jsonEncDriver) encodeBase64(v []byte) {
+	var bs []byte
+	if l := base64.StdEncoding.EncodedLen(len(v)); l <= len(e.b) {
+		bs = e.b[:l]
+	} else {
+		bs = make([]byte, l)
+	}
+	base64.StdEncoding.Encode(bs, v)
+	e.w.writen1('"')
+	e.w.writeb(bs)
+	e.w.writen1('"')
+}
+
+const jsonHex = "0123456789abcdef"
+
+// quoteStr writes s as a quoted JSON string, escaping as necessary.
+// Invalid UTF-8 sequences are replaced with U+FFFD.
+func (e *jsonEncDriver) quoteStr(s string) {
+	w := e.w
+	w.writen1('"')
+	start := 0
+	for i := 0; i < len(s); {
+		if b := s[i]; b < utf8.RuneSelf {
+			if b >= 0x20 && b != '"' && b != '\\' {
+				i++
+				continue
+			}
+			if start < i {
+				w.writestr(s[start:i])
+			}
+			switch b {
+			case '"', '\\':
+				w.writen2('\\', b)
+			case '\n':
+				w.writen2('\\', 'n')
+			case '\r':
+				w.writen2('\\', 'r')
+			case '\t':
+				w.writen2('\\', 't')
+			default:
+				w.writestr(`\u00`)
+				w.writen2(jsonHex[b>>4], jsonHex[b&0xF])
+			}
+			i++
+			start = i
+			continue
+		}
+		c, size := utf8.DecodeRuneInString(s[i:])
+		if c == utf8.RuneError && size == 1 {
+			if start < i {
+				w.writestr(s[start:i])
+			}
+			w.writestr(`\ufffd`)
+			i += size
+			start = i
+			continue
+		}
+		// U+2028 and U+2029 are valid JSON, but are line terminators in javascript.
+		if c == '\u2028' || c == '\u2029' {
+			if start < i {
+				w.writestr(s[start:i])
+			}
+			w.writestr(`\u202`)
+			w.writen1(jsonHex[c&0xF])
+			i += size
+			start = i
+			continue
+		}
+		i += size
+	}
+	if start < len(s) {
+		w.writestr(s[start:])
+	}
+	w.writen1('"')
+}
+
+func (e *jsonEncDriver) reset(w encWriter) {
+	e.w = w
+	e.k = false
+}
+
+// ---------------------------------------------
+
+func (d *jsonDecDriver) reset(r decReader) {
+	d.r = r
+	d.bdRead, d.cr = false, false
+	d.ct = d.ct[:0]
+}
+
+// readn1 returns the next byte, honoring a byte previously read ahead.
+func (d *jsonDecDriver) readn1() (b byte) {
+	if d.cr {
+		d.cr = false
+		return d.c
+	}
+	return d.r.readn1()
+}
+
+// skipWhitespace returns the next non-whitespace byte.
+func (d *jsonDecDriver) skipWhitespace() (b byte) {
+	for {
+		switch b = d.readn1(); b {
+		case ' ', '\t', '\r', '\n':
+		default:
+			return
+		}
+	}
+}
+
+func (d *jsonDecDriver) readLiteral(bd byte, rest string) {
+	for i := 0; i < len(rest); i++ {
+		if b := d.readn1(); b != rest[i] {
+			decErr("json: invalid literal starting with %q. Got %q at position %v", bd, b, i+1)
+		}
+	}
+}
+
+// Every top-level decode funcs (i.e. decodeValue, decode) must call this first.
+func (d *jsonDecDriver) initReadNext() {
+	if d.bdRead {
+		return
+	}
+	d.bd = d.skipWhitespace()
+	d.bdRead = true
+	d.bdType = detUnset
+}
+
+func (d *jsonDecDriver) currentEncodedType() decodeEncodedType {
+	if d.bdType == detUnset {
+		switch bd := d.bd; bd {
+		case 'n':
+			d.bdType = detNil
+		case 't', 'f':
+			d.bdType = detBool
+		case '"':
+			d.bdType = detString
+		case '[':
+			d.bdType = detArray
+		case '{':
+			d.bdType = detMap
+		default:
+			if bd == '-' || (bd >= '0' && bd <= '9') {
+				d.bdType = detFloat
+			} else {
+				decErr("json: currentEncodedType: unexpected character: %q", bd)
+			}
+		}
+	}
+	return d.bdType
+}
+
+func (d *jsonDecDriver) tryDecodeAsNil() bool {
+	if d.bd == 'n' {
+		d.readLiteral('n', "ull")
+		d.bdRead = false
+		return true
+	}
+	return false
+}
+
+func (d *jsonDecDriver) isBuiltinType(rt uintptr) bool {
+	return rt == timeTypId
+}
+
+func (d *jsonDecDriver) decodeBuiltinType(rt uintptr, rv reflect.Value) {
+	switch rt {
+	case timeTypId:
+		tt, err := time.Parse(time.RFC3339Nano, d.decodeString())
+		if err != nil {
+			panic(err)
+		}
+		rv.Set(reflect.ValueOf(tt))
+	}
+}
+
+// readNumber reads a number literal into d.s, returning it.
+// The number may be quoted (e.g. when used as a map key).
+func (d *jsonDecDriver) readNumber() []byte {
+	if d.bd == '"' {
+		d.readString()
+	} else {
+		d.s = append(d.s[:0], d.bd)
+		for {
+			b, eof := d.r.readn1eof()
+			if eof {
+				break
+			}
+			if (b >= '0' && b <= '9') || b == '.' || b == '-' || b == '+' || b == 'e' || b == 'E' {
+				d.s = append(d.s, b)
+				continue
+			}
+			d.c, d.cr = b, true
+			break
+		}
+		d.bdRead = false
+	}
+	if !jsonValidNumber(d.s) {
+		decErr("json: invalid number: %s", d.s)
+	}
+	return d.s
+}
+
+// jsonValidNumber checks bs against the number grammar of RFC 7159:
+//   [ - ] ( 0 | [1-9] *DIGIT ) [ . 1*DIGIT ] [ ( e | E ) [ - | + ] 1*DIGIT ]
+func jsonValidNumber(bs []byte) bool {
+	i, n := 0, len(bs)
+	digits := func() (m int) {
+		for ; i < n && bs[i] >= '0' && bs[i] <= '9'; i++ {
+			m++
+		}
+		return
+	}
+	if i < n && bs[i] == '-' {
+		i++
+	}
+	if i < n && bs[i] == '0' {
+		i++
+	} else if digits() == 0 {
+		return false
+	}
+	if i < n && bs[i] == '.' {
+		i++
+		if digits() == 0 {
+			return false
+		}
+	}
+	if i < n && (bs[i] == 'e' || bs[i] == 'E') {
+		i++
+		if i < n && (bs[i] == '-' || bs[i] == '+') {
+			i++
+		}
+		if digits() == 0 {
+			return false
+		}
+	}
+	return i == n
+}
+
+func (d *jsonDecDriver) decodeInt(bitsize uint8) (i int64) {
+	bs := d.readNumber()
+	i, err := strconv.ParseInt(string(bs), 10, 64)
+	if err != nil {
+		// allow integral values written with a fraction or exponent, e.g. 1.0 or 1e3
+		f, err2 := strconv.ParseFloat(string(bs), 64)
+		if err2 != nil || f != math.Trunc(f) || f < math.MinInt64 || f >= math.MaxInt64 {
+			decErr("json: invalid int value: %s: %v", bs, err)
+		}
+		i = int64(f)
+	}
+	// check overflow (logic adapted from std pkg reflect/value.go OverflowUint()
+	if bitsize > 0 {
+		if trunc := (i << (64 - bitsize)) >> (64 - bitsize); i != trunc {
+			decErr("Overflow int value: %v", i)
+		}
+	}
+	return
+}
+
+func (d *jsonDecDriver) decodeUint(bitsize uint8) (ui uint64) {
+	bs := d.readNumber()
+	if len(bs) > 0 && bs[0] == '-' {
+		decErr("Assigning negative signed value: %s, to unsigned type", bs)
+	}
+	ui, err := strconv.ParseUint(string(bs), 10, 64)
+	if err != nil {
+		f, err2 := strconv.ParseFloat(string(bs), 64)
+		if err2 != nil || f != math.Trunc(f) || f >= math.MaxUint64 {
+			decErr("json: invalid uint value: %s: %v", bs, err)
+		}
+		ui = uint64(f)
+	}
+	// check overflow (logic adapted from std pkg reflect/value.go OverflowUint()
+	if bitsize > 0 {
+		if trunc := (ui << (64 - bitsize)) >> (64 - bitsize); ui != trunc {
+			decErr("Overflow uint value: %v", ui)
+		}
+	}
+	return
+}
+
+func (d *jsonDecDriver) decodeFloat(chkOverflow32 bool) (f float64) {
+	bs := d.readNumber()
+	f, err := strconv.ParseFloat(string(bs), 64)
+	if err != nil {
+		decErr("json: invalid float value: %s: %v", bs, err)
+	}
+	// check overflow (logic adapted from std pkg reflect/value.go OverflowFloat()
+	if chkOverflow32 {
+		f2 := f
+		if f2 < 0 {
+			f2 = -f
+		}
+		if math.MaxFloat32 < f2 && f2 <= math.MaxFloat64 {
+			decErr("Overflow float32 value: %v", f2)
+		}
+	}
+	return
+}
+
+// bool can be decoded from true or false, quoted or not.
+func (d *jsonDecDriver) decodeBool() (b bool) {
+	bd := d.bd
+	quoted := bd == '"'
+	if quoted {
+		bd = d.readn1()
+	}
+	switch bd {
+	case 't':
+		d.readLiteral(bd, "rue")
+		b = true
+	case 'f':
+		d.readLiteral(bd, "alse")
+	default:
+		decErr("json: invalid character for bool: %q", bd)
+	}
+	if quoted {
+		d.readLiteral(bd, `"`)
+	}
+	d.bdRead = false
+	return
+}
+
+func (d *jsonDecDriver) decodeString() (s string) {
+	return string(d.readString())
+}
+
+func (d *jsonDecDriver) decodeBytes(bs []byte) (bsOut []byte, changed bool) {
+	bs0 := d.readString()
+	clen := base64.StdEncoding.DecodedLen(len(bs0))
+	if clen == 0 {
+		// if no contents in stream, don't update the passed byteslice
+		return
+	}
+	if len(bs) != clen {
+		// Return changed=true if length of passed slice diff from length of bytes in stream
+		if len(bs) > clen {
+			bs = bs[:clen]
+		} else {
+			bs = make([]byte, clen)
+		}
+		bsOut = bs
+		changed = true
+	}
+	n, err := base64.StdEncoding.Decode(bs, bs0)
+	if err != nil {
+		decErr("json: invalid base64 string: %v", err)
+	}
+	if n != len(bs) {
+		bsOut = bs[:n]
+		changed = true
+	}
+	return
+}
+
+func (d *jsonDecDriver) decodeExt(verifyTag bool, tag byte) (xtag byte, xbs []byte) {
+	xtag = tag
+	xbs, _ = d.decodeBytes(nil)
+	return
+}
+
+// readString reads a quoted string into d.s, unescaping as it goes.
+func (d *jsonDecDriver) readString() []byte {
+	if d.bd != '"' {
+		decErr("json: expecting string starting with '\"'. Got: %q", d.bd)
+	}
+	d.s = d.s[:0]
+	for {
+		c := d.readn1()
+		if c == '"' {
+			break
+		}
+		if c != '\\' {
+			if c < 0x20 {
+				decErr("json: invalid control character in string: %q", c)
+			}
+			d.s = append(d.s, c)
+			d.h.checkBytesLen(len(d.s))
+			continue
+		}
+		d.appendEscape(d.readn1())
+	}
+	d.bdRead = false
+	return d.s
+}
+
+// appendEscape appends to d.s the character for the escape sequence \c.
+func (d *jsonDecDriver) appendEscape(c byte) {
+	switch c {
+	case '"', '\\', '/':
+		d.s = append(d.s, c)
+	case 'b':
+		d.s = append(d.s, '\b')
+	case 'f':
+		d.s = append(d.s, '\f')
+	case 'n':
+		d.s = append(d.s, '\n')
+	case 'r':
+		d.s = append(d.s, '\r')
+	case 't':
+		d.s = append(d.s, '\t')
+	case 'u':
+		d.appendUnicodeEscape()
+	default:
+		decErr("json: invalid escape sequence in string: \\%c", c)
+	}
+}
+
+// appendUnicodeEscape appends to d.s the character for a \uXXXX escape (after the \u).
+// A high surrogate is paired with a low surrogate escape immediately following it.
+// Else, the lone surrogate is replaced by U+FFFD, and what follows it is read as usual.
+func (d *jsonDecDriver) appendUnicodeEscape() {
+	r := d.readHex4()
+	for utf16.IsSurrogate(r) {
+		if r >= 0xdc00 {
+			r = utf8.RuneError
+			break
+		}
+		c := d.readn1()
+		if c != '\\' {
+			d.c, d.cr = c, true
+			r = utf8.RuneError
+			break
+		}
+		if c = d.readn1(); c != 'u' {
+			d.appendRune(utf8.RuneError)
+			d.appendEscape(c)
+			return
+		}
+		r2 := d.readHex4()
+		if r2 >= 0xdc00 && r2 <= 0xdfff {
+			r = utf16.DecodeRune(r, r2)
+			break
+		}
+		// r2 is read as usual: it may start another surrogate pair
+		d.appendRune(utf8.RuneError)
+		r = r2
+	}
+	d.appendRune(r)
+}
+
+func (d *jsonDecDriver) appendRune(r rune) {
+	var b [utf8.UTFMax]byte
+	d.s = append(d.s, b[:utf8.EncodeRune(b[:], r)]...)
+}
+
+func (d *jsonDecDriver) readHex4() (r rune) {
+	for i := 0; i < 4; i++ {
+		c := d.readn1()
+		switch {
+		case c >= '0' && c <= '9':
+			c = c - '0'
+		case c >= 'a' && c <= 'f':
+			c = c - 'a' + 10
+		case c >= 'A' && c <= 'F':
+			c = c - 'A' + 10
+		default:
+			decErr("json: invalid hex character in \\u escape: %q", c)
+		}
+		r = r<<4 | rune(c)
+	}
+	return
+}
+
+func (d *jsonDecDriver) readContainerStart(bd byte) {
+	if d.bd != bd {
+		decErr("json: expecting %q. Got: %q", bd, d.bd)
+	}
+	d.ct = append(d.ct, bd)
+	d.bdRead = false
+}
+
+func (d *jsonDecDriver) readMapLen() int {
+	d.readContainerStart('{')
+	return -1
+}
+
+func (d *jsonDecDriver) readArrayLen() int {
+	d.readContainerStart('[')
+	return -1
+}
+
+func (d *jsonDecDriver) checkBreak() bool {
+	d.initReadNext()
+	var open byte
+	switch d.bd {
+	case ']':
+		open = '['
+	case '}':
+		open = '{'
+	default:
+		return false
+	}
+	if n := len(d.ct); n == 0 || d.ct[n-1] != open {
+		decErr("json: unexpected character: %q", d.bd)
+	} else {
+		d.ct = d.ct[:n-1]
+	}
+	d.bdRead = false
+	return true
+}
+
+func (d *jsonDecDriver) readSeparator(c byte) {
+	d.initReadNext()
+	if d.bd != c {
+		decErr("json: expecting %q. Got: %q", c, d.bd)
+	}
+	d.bdRead = false
+}
+
+func (d *jsonDecDriver) readArrayEntrySeparator() {
+	d.readSeparator(',')
+}
+
+func (d *jsonDecDriver) readMapEntrySeparator() {
+	d.readSeparator(',')
+}
+
+func (d *jsonDecDriver) readMapKVSeparator() {
+	d.readSeparator(':')
+}
+
+func (d *jsonDecDriver) decodeRaw() []byte {
+	d.initReadNext()
+	bd := d.bd
+	d.r.track()
+	d.swallow()
+	bs := d.r.stopTrack()
+	if d.cr {
+		// the byte read ahead (e.g. at the end of a number) is not part of the value
+		bs = bs[:len(bs)-1]
+	}
+	return decRawBytes(bd, bs)
+}
+
+func (d *jsonDecDriver) swallow() {
+	d.swallowDepth(0)
+}
+
+func (d *jsonDecDriver) swallowDepth(depth int) {
+	d.initReadNext()
+	switch bd := d.bd; bd {
+	case 'n':
+		d.readLiteral(bd, "ull")
+		d.bdRead = false
+	case 't', 'f':
+		d.decodeBool()
+	case '"':
+		d.readString()
+	case '[':
+		d.readArrayLen()
+		depth++
+		d.h.checkDepth(depth)
+		for j := 0; !d.checkBreak(); j++ {
+			if j > 0 {
+				d.readArrayEntrySeparator()
+			}
+			d.swallowDepth(depth)
+		}
+	case '{':
+		d.readMapLen()
+		depth++
+		d.h.checkDepth(depth)
+		for j := 0; !d.checkBreak(); j++ {
+			if j > 0 {
+				d.readMapEntrySeparator()
+			}
+			d.swallowDepth(depth)
+			d.readMapKVSeparator()
+			d.swallowDepth(depth)
+		}
+	default:
+		if bd != '-' && (bd < '0' || bd > '9') {
+			decErr("json: swallow: unexpected character: %q", bd)
+		}
+		d.readNumber()
+	}
+}
+
+// Note: This returns either a primitive (int, bool, etc) for non-containers,
+// or a containerType, or a specific type denoting nil.
+// It is called when a nil interface{} is passed, leaving it up to the DecDriver
+// to introspect the stream and decide how best to decode.
+func (d *jsonDecDriver) decodeNaked() (rv reflect.Value, ctx decodeNakedContext) {
+	d.initReadNext()
+
+	var v interface{}
+
+	switch bd := d.bd; bd {
+	case 'n':
+		d.readLiteral(bd, "ull")
+		d.bdRead = false
+		ctx = dncNil
+	case 't', 'f':
+		v = d.decodeBool()
+	case '"':
+		v = d.decodeString()
+	case '[':
+		ctx = dncContainer
+		if d.h.SliceType == nil {
+			rv = reflect.New(intfSliceTyp).Elem()
+		} else {
+			rv = reflect.New(d.h.SliceType).Elem()
+		}
+	case '{':
+		ctx = dncContainer
+		if d.h.MapType == nil {
+			rv = reflect.MakeMap(mapStringIntfTyp)
+		} else {
+			rv = reflect.MakeMap(d.h.MapType)
+		}
+	default:
+		if bd != '-' && (bd < '0' || bd > '9') {
+			decErr("json: Nil-Deciphered DecodeValue: unexpected character: %q", bd)
+		}
+		bs := d.readNumber()
+		isFloat := false
+		for _, c := range bs {
+			if c == '.' || c == 'e' || c == 'E' {
+				isFloat = true
+				break
+			}
+		}
+		var err error
+		if !isFloat {
+			if bs[0] == '-' {
+				v, err = strconv.ParseInt(string(bs), 10, 64)
+			} else {
+				v, err = strconv.ParseUint(string(bs), 10, 64)
+			}
+			// integers which overflow 64 bits are decoded as float64
+			isFloat = err != nil
+		}
+		if isFloat {
+			if v, err = strconv.ParseFloat(string(bs), 64); err != nil {
+				decErr("json: invalid number: %s: %v", bs, err)
+			}
+		}
+	}
+	if ctx == dncHandled {
+		d.bdRead = false
+		if v != nil {
+			rv = reflect.ValueOf(v)
+		}
+	}
+	return
+}
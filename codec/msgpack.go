@@ -62,6 +62,8 @@ const (
 	mpNegFixNumMin      = 0xe0
 	mpNegFixNumMax      = 0xff
 
+	// extension type -1, defined by the spec for timestamps
+	mpTimeExtTag byte = 0xff
 )
 
 // MsgpackSpecRpc implements Rpc using the communication protocol defined in
@@ -112,19 +114,30 @@ type MsgpackHandle struct {
 	// is provided, but the type cannot be inferred from the stream. If no appropriate
 	// type is provided (e.g. decoding into a nil interface{}), you get back
 	// a []byte or string based on the setting of RawToString.
+	//
+	// time.Time is supported natively using the spec-defined timestamp extension (type -1).
+	// With WriteExt=false, the timestamp bytes are written as raw bytes.
+	// To keep using the legacy layout (for compatibility with old streams),
+	// register TimeEncodeExt and TimeDecodeExt for time.Time using AddExt.
 	WriteExt bool
 
+	// bigExtTag, if non-zero, is the extension tag of math/big values (see SetBigExtTag).
+	bigExtTag byte
+
+	BasicHandle
 	extHandle
 	EncodeOptions
 	DecodeOptions
 }
 
 type msgpackEncDriver struct {
+	encNoSeparator
 	w encWriter
 	h *MsgpackHandle
 }
 
 type msgpackDecDriver struct {
+	decNoSeparator
 	r      decReader
 	h      *MsgpackHandle
 	bd     byte
@@ -133,17 +146,44 @@ type msgpackDecDriver struct {
 }
 
 func (e *msgpackEncDriver) isBuiltinType(rt uintptr) bool {
-	//no builtin types. All encodings are based on kinds. Types supported as extensions.
-	return false
+	// time.Time is builtin (timestamp extension), unless an extension is registered for it.
+	if rt == timeTypId {
+		return e.h.getExt(rt) == nil
+	}
+	return isBigTypId(rt) && e.h.bigExt()
 }
 	
-func (e *msgpackEncDriver) encodeBuiltinType(rt uintptr, rv reflect.Value) {}
+func (e *msgpackEncDriver) encodeBuiltinType(rt uintptr, rv reflect.Value) {
+	switch rt {
+	case timeTypId:
+		bs := encodeMsgpackTime(rv.Interface().(time.Time))
+		if e.h.WriteExt {
+			e.encodeExtPreamble(mpTimeExtTag, len(bs))
+			e.w.writeb(bs)
+		} else {
+			e.encodeStringBytes(c_RAW, bs)
+		}
+	case bigIntTypId, bigFloatTypId, bigRatTypId:
+		kind, s := encodeBigText(rv)
+		if e.h.WriteExt {
+			e.encodeExtPreamble(e.h.bigExtTag, len(s)+1)
+			e.w.writen1(kind)
+			e.w.writestr(s)
+		} else {
+			e.encodeStringBytes(c_RAW, append([]byte{kind}, s...))
+		}
+	}
+}
 
 func (e *msgpackEncDriver) encodeNil() {
 	e.w.writen1(mpNil)
 }
 
 func (e *msgpackEncDriver) encodeInt(i int64) {
+	if i >= 0 && e.h.Canonical {
+		e.encodeUint(uint64(i))
+		return
+	}
 	switch {
 	case i >= -32 && i <= math.MaxInt8:
 		e.w.writen1(byte(i))
@@ -165,9 +205,10 @@ func (e *msgpackEncDriver) encodeInt(i int64) {
 
 func (e *msgpackEncDriver) encodeUint(i uint64) {
 	// uints are not fixnums. fixnums are always signed.
-	// case i <= math.MaxInt8:
-	// 	e.w.writen1(byte(i))
+	// However, in canonical mode, a number is written the same way regardless of its type.
 	switch {
+	case i <= math.MaxInt8 && e.h.Canonical:
+		e.w.writen1(byte(i))
 	case i <= math.MaxUint8:
 		e.w.writen2(mpUint8, byte(i))
 	case i <= math.MaxUint16:
@@ -196,6 +237,10 @@ func (e *msgpackEncDriver) encodeFloat32(f float32) {
 }
 
 func (e *msgpackEncDriver) encodeFloat64(f float64) {
+	if e.h.Canonical && float64(float32(f)) == f {
+		e.encodeFloat32(float32(f))
+		return
+	}
 	e.w.writen1(mpDouble)
 	e.w.writeUint64(math.Float64bits(f))
 }
@@ -275,14 +320,51 @@ func (e *msgpackEncDriver) writeContainerLen(ct msgpackContainerType, l int) {
 	}
 }
 
+func (e *msgpackEncDriver) reset(w encWriter) {
+	e.w = w
+}
+
 //---------------------------------------------
 
+func (d *msgpackDecDriver) reset(r decReader) {
+	d.r = r
+	d.bdRead = false
+}
+
 func (d *msgpackDecDriver) isBuiltinType(rt uintptr) bool {
-	//no builtin types. All encodings are based on kinds. Types supported as extensions.
-	return false
+	// time.Time is builtin (timestamp extension), unless an extension is registered for it.
+	if rt == timeTypId {
+		return d.h.getExt(rt) == nil
+	}
+	return isBigTypId(rt) && d.h.bigExt()
 }
 	
-func (d *msgpackDecDriver) decodeBuiltinType(rt uintptr, rv reflect.Value) {}
+func (d *msgpackDecDriver) decodeBuiltinType(rt uintptr, rv reflect.Value) {
+	switch rt {
+	case timeTypId:
+		_, xbs := d.decodeExt(true, mpTimeExtTag)
+		tt, err := decodeMsgpackTime(xbs)
+		if err != nil {
+			panic(err)
+		}
+		rv.Set(reflect.ValueOf(tt))
+	case bigIntTypId, bigFloatTypId, bigRatTypId:
+		switch d.currentEncodedType() {
+		case detInt:
+			setBigNumber(rv, d.decodeInt(0))
+		case detUint:
+			setBigNumber(rv, d.decodeUint(0))
+		case detFloat:
+			setBigNumber(rv, d.decodeFloat(false))
+		default:
+			_, xbs := d.decodeExt(true, d.h.bigExtTag)
+			if len(xbs) == 0 {
+				decErr("Invalid math/big extension: empty payload")
+			}
+			decodeBigText(rv, string(xbs[1:]))
+		}
+	}
+}
 
 // Note: This returns either a primitive (int, bool, etc) for non-containers,
 // or a containerType, or a specific type denoting nil or extension.
@@ -371,12 +453,30 @@ func (d *msgpackDecDriver) decodeNaked() (rv reflect.Value, ctx decodeNakedConte
 			//ctx = dncExt
 			clen := d.readExtLen()
 			xtag := d.r.readn1()
-			var bfn func(reflect.Value, []byte) error
-			rv, bfn = d.h.getDecodeExtForTag(xtag)
-			if bfn == nil {
-				decErr("Unable to find type mapped to extension tag: %v", xtag)
+			var xf *extTypeTagFn
+			rv, xf = d.h.getDecodeExtForTag(xtag)
+			if xf == nil {
+				if d.h.bigExt() && xtag == d.h.bigExtTag {
+					xbs := d.r.readn(clen)
+					if len(xbs) == 0 {
+						decErr("Invalid math/big extension: empty payload")
+					}
+					v = decodeBigNaked(xbs[0], string(xbs[1:]))
+					break
+				}
+				if xtag != mpTimeExtTag {
+					// unregistered tag: keep it as is
+					v = RawExt{xtag, append(make([]byte, 0, clen), d.r.readn(clen)...)}
+					break
+				}
+				tt, err := decodeMsgpackTime(d.r.readn(clen))
+				if err != nil {
+					panic(err)
+				}
+				v = tt
+				break
 			}
-			if fnerr := bfn(rv, d.r.readn(clen)); fnerr != nil {
+			if fnerr := xf.decode(d.h, rv, d.r.readn(clen)); fnerr != nil {
 				panic(fnerr)
 			}
 		default:
@@ -527,6 +627,7 @@ func (d *msgpackDecDriver) decodeBool() (b bool) {
 func (d *msgpackDecDriver) decodeString() (s string) {
 	clen := d.readContainerLen(msgpackContainerStr)
 	if clen > 0 {
+		d.h.checkBytesLen(clen)
 		s = string(d.r.readn(clen))
 	}
 	d.bdRead = false
@@ -548,6 +649,7 @@ func (d *msgpackDecDriver) decodeBytes(bs []byte) (bsOut []byte, changed bool) {
 	// 	panic("length cannot be zero. this cannot be nil.")
 	// }
 	if clen > 0 {
+		d.h.checkBytesLen(clen)
 		// if no contents in stream, don't update the passed byteslice
 		if len(bs) != clen {
 			// Return changed=true if length of passed slice diff from length of bytes in stream
@@ -646,12 +748,16 @@ func (d *msgpackDecDriver) readContainerLen(ct msgpackContainerType) (clen int)
 	return
 }
 
-func (d *msgpackDecDriver) readMapLen() int {
-	return d.readContainerLen(msgpackContainerMap)
+func (d *msgpackDecDriver) readMapLen() (clen int) {
+	clen = d.readContainerLen(msgpackContainerMap)
+	d.h.checkContainerLen(clen)
+	return
 }
 
-func (d *msgpackDecDriver) readArrayLen() int {
-	return d.readContainerLen(msgpackContainerList)
+func (d *msgpackDecDriver) readArrayLen() (clen int) {
+	clen = d.readContainerLen(msgpackContainerList)
+	d.h.checkContainerLen(clen)
+	return
 }
 
 func (d *msgpackDecDriver) readExtLen() (clen int) {
@@ -677,11 +783,13 @@ func (d *msgpackDecDriver) readExtLen() (clen int) {
 	default:
 		decErr("decoding ext bytes: found unexpected byte: %x", d.bd)
 	}
+	d.h.checkBytesLen(clen)
 	return
 }
 
-func (d *msgpackDecDriver) decodeExt(tag byte) (xbs []byte) {
+func (d *msgpackDecDriver) decodeExt(verifyTag bool, tag byte) (xtag byte, xbs []byte) {
 	xbd := d.bd
+	xtag = tag
 	switch {
 	case xbd == mpBin8, xbd == mpBin16, xbd == mpBin32: 
 		xbs, _ = d.decodeBytes(nil) 
@@ -690,7 +798,7 @@ func (d *msgpackDecDriver) decodeExt(tag byte) (xbs []byte) {
 		xbs = []byte(d.decodeString())
 	default:
 		clen := d.readExtLen()
-		if xtag := d.r.readn1(); xtag != tag {
+		if xtag = d.r.readn1(); verifyTag && xtag != tag {
 			decErr("Wrong extension tag. Got %b. Expecting: %v", xtag, tag)
 		}
 		xbs = d.r.readn(clen)
@@ -699,6 +807,60 @@ func (d *msgpackDecDriver) decodeExt(tag byte) (xbs []byte) {
 	return
 }
 
+func (d *msgpackDecDriver) decodeRaw() []byte {
+	d.initReadNext()
+	bd := d.bd
+	d.r.track()
+	d.swallow()
+	return decRawBytes(bd, d.r.stopTrack())
+}
+
+func (d *msgpackDecDriver) swallow() {
+	for left := 1; left > 0; left-- {
+		d.swallow1(&left)
+	}
+}
+
+func (d *msgpackDecDriver) swallow1(left *int) {
+	d.initReadNext()
+	bd := d.bd
+	var n int
+	switch bd {
+	case mpNil, mpFalse, mpTrue:
+	case mpUint8, mpInt8:
+		n = 1
+	case mpUint16, mpInt16:
+		n = 2
+	case mpFloat, mpUint32, mpInt32:
+		n = 4
+	case mpDouble, mpUint64, mpInt64:
+		n = 8
+	case mpBin8, mpBin16, mpBin32:
+		n = d.readContainerLen(msgpackContainerBin)
+		d.h.checkBytesLen(n)
+	default:
+		switch {
+		case bd >= mpPosFixNumMin && bd <= mpPosFixNumMax:
+		case bd >= mpNegFixNumMin && bd <= mpNegFixNumMax:
+		case bd == mpStr8, bd == mpStr16, bd == mpStr32, bd >= mpFixStrMin && bd <= mpFixStrMax:
+			n = d.readContainerLen(msgpackContainerStr)
+			d.h.checkBytesLen(n)
+		case bd == mpArray16, bd == mpArray32, bd >= mpFixArrayMin && bd <= mpFixArrayMax:
+			*left += d.readArrayLen()
+		case bd == mpMap16, bd == mpMap32, bd >= mpFixMapMin && bd <= mpFixMapMax:
+			*left += 2 * d.readMapLen()
+		case bd >= mpFixExt1 && bd <= mpFixExt16, bd >= mpExt8 && bd <= mpExt32:
+			n = d.readExtLen()
+			d.h.checkBytesLen(n)
+			n++ // include the tag
+		default:
+			decErr("swallow: %s: hex: %x, dec: %d", msgBadDesc, bd, bd)
+		}
+	}
+	d.bdRead = false
+	d.r.skip(n)
+}
+
 //--------------------------------------------------
 
 func (x msgpackSpecRpc) ServerCodec(conn io.ReadWriteCloser, h Handle) rpc.ServerCodec {
@@ -790,8 +952,80 @@ func (c *msgpackSpecRpcCodec) parseCustomHeader(expectTypeByte byte, msgid *uint
 
 //--------------------------------------------------
 
-// TimeEncodeExt encodes a time.Time as a byte slice.
-// Configure this to support the Time Extension, e.g. using tag 1.
+// BinaryEncodeExt returns the contents of a []byte (or a named type whose
+// underlying type is []byte) as the extension data.
+// Configure this to support the Binary Extension, e.g. using tag 0.
+func (_ *MsgpackHandle) BinaryEncodeExt(rv reflect.Value) (bs []byte, err error) {
+	if rv.Kind() != reflect.Slice || rv.Type().Elem().Kind() != reflect.Uint8 {
+		err = fmt.Errorf("codec/msgpack: BinaryEncodeExt expects a []byte. Received %v", rv.Type())
+		return
+	}
+	bs = rv.Bytes()
+	return
+}
+
+// BinaryDecodeExt sets a copy of the extension data into a []byte
+// (or a named type whose underlying type is []byte).
+// Configure this to support the Binary Extension, e.g. using tag 0.
+func (_ *MsgpackHandle) BinaryDecodeExt(rv reflect.Value, bs []byte) (err error) {
+	if rv.Kind() != reflect.Slice || rv.Type().Elem().Kind() != reflect.Uint8 {
+		return fmt.Errorf("codec/msgpack: BinaryDecodeExt expects a []byte. Received %v", rv.Type())
+	}
+	// copy, as bs may be a view into the input of the decoder
+	bs2 := make([]byte, len(bs))
+	copy(bs2, bs)
+	rv.SetBytes(bs2)
+	return
+}
+
+// encodeMsgpackTime encodes a time.Time as the data of the spec-defined
+// timestamp extension, using the smallest of the timestamp 32, 64 and 96 formats.
+func encodeMsgpackTime(t time.Time) (bs []byte) {
+	secs, nsecs := t.Unix(), uint32(t.Nanosecond())
+	switch {
+	case secs >= 0 && secs <= math.MaxUint32 && nsecs == 0:
+		bs = make([]byte, 4)
+		bigen.PutUint32(bs, uint32(secs))
+	case secs >= 0 && secs < 1<<34:
+		bs = make([]byte, 8)
+		bigen.PutUint64(bs, uint64(nsecs)<<34|uint64(secs))
+	default:
+		bs = make([]byte, 12)
+		bigen.PutUint32(bs, nsecs)
+		bigen.PutUint64(bs[4:], uint64(secs))
+	}
+	return
+}
+
+// decodeMsgpackTime decodes a time.Time (in UTC) from the data of the
+// spec-defined timestamp extension.
+func decodeMsgpackTime(bs []byte) (t time.Time, err error) {
+	var secs int64
+	var nsecs uint32
+	switch len(bs) {
+	case 4:
+		secs = int64(bigen.Uint32(bs))
+	case 8:
+		v := bigen.Uint64(bs)
+		secs, nsecs = int64(v&(1<<34-1)), uint32(v>>34)
+	case 12:
+		nsecs, secs = bigen.Uint32(bs), int64(bigen.Uint64(bs[4:]))
+	default:
+		err = fmt.Errorf("codec/msgpack: invalid length of timestamp extension: %v", len(bs))
+		return
+	}
+	if nsecs > 999999999 {
+		err = fmt.Errorf("codec/msgpack: invalid nanoseconds in timestamp extension: %v", nsecs)
+		return
+	}
+	t = time.Unix(secs, int64(nsecs)).UTC()
+	return
+}
+
+// TimeEncodeExt encodes a time.Time as a byte slice, using the legacy layout
+// (shared with binc) which also records the UTC offset. 
+// Configure this to keep writing streams readable by older versions, e.g. using tag 1.
+// When registered, it takes precedence over the builtin timestamp extension.
 func (_ *MsgpackHandle) TimeEncodeExt(rv reflect.Value) (bs []byte, err error) {
 	rvi := rv.Interface()
 	switch iv := rvi.(type) {
@@ -803,8 +1037,8 @@ func (_ *MsgpackHandle) TimeEncodeExt(rv reflect.Value) (bs []byte, err error) {
 	return
 }
 
-// TimeDecodeExt decodes a time.Time from the byte slice parameter, and sets it into the reflect value.
-// Configure this to support the Time Extension, e.g. using tag 1.
+// TimeDecodeExt decodes a time.Time from the byte slice parameter (in the legacy layout),
+// and sets it into the reflect value. Configure it alongside TimeEncodeExt.
 func (_ *MsgpackHandle) TimeDecodeExt(rv reflect.Value, bs []byte) (err error) {
 	tt, err := decodeTime(bs)
 	if err == nil {
@@ -821,7 +1055,62 @@ func (h *MsgpackHandle) newDecDriver(r decReader) decDriver {
 	return &msgpackDecDriver{r: r, h: h}
 }
 
+func (_ *MsgpackHandle) valueSeparator() bool {
+	return false
+}
+
+func (_ *MsgpackHandle) nativeJson() bool {
+	return false
+}
+
 func (h *MsgpackHandle) writeExt() bool {
 	return h.WriteExt
 }
 
+// bigExt reports whether math/big values are supported (see SetBigExtTag).
+func (h *MsgpackHandle) bigExt() bool {
+	return h.bigExtTag != 0
+}
+
+// SetBigExtTag enables support for math/big values (big.Int, big.Float and big.Rat, 
+// and pointers to them), encoded as an extension with the given tag. A tag of 0 disables it.
+// The tag cannot be the timestamp extension tag (-1), or the tag of a registered extension.
+// 
+// The payload is a kind byte ('i', 'f' or 'r') followed by the value as text 
+// (e.g. "-12", "1.5e+100" or "1/3"). Such extensions are decoded into a *big.Int, 
+// *big.Float or *big.Rat when decoding into a nil interface{}. 
+// Integers and floats can also be decoded into math/big values.
+func (h *MsgpackHandle) SetBigExtTag(tag byte) (err error) {
+	if tag == mpTimeExtTag {
+		return fmt.Errorf("codec/msgpack: SetBigExtTag: Tag %v is the timestamp extension tag", tag)
+	}
+	if x := h.tags[tag]; tag != 0 && x != nil {
+		return fmt.Errorf("codec/msgpack: SetBigExtTag: Tag %v already registered for type %v", tag, x.rt)
+	}
+	h.bigExtTag = tag
+	return
+}
+
+// AddExt registers an extension (see extHandle.AddExt). 
+// Its tag cannot be the tag of math/big values (see SetBigExtTag).
+func (h *MsgpackHandle) AddExt(
+	rt reflect.Type,
+	tag byte,
+	encfn func(reflect.Value) ([]byte, error),
+	decfn func(reflect.Value, []byte) error,
+) (err error) {
+	if encfn != nil && decfn != nil && h.bigExtTag != 0 && tag == h.bigExtTag {
+		return fmt.Errorf("codec/msgpack: AddExt: Tag %v is the math/big extension tag", tag)
+	}
+	return h.extHandle.AddExt(rt, tag, encfn, decfn)
+}
+
+// SetExt registers an extension (see extHandle.SetExt). 
+// Its tag cannot be the tag of math/big values (see SetBigExtTag).
+func (h *MsgpackHandle) SetExt(rt reflect.Type, tag byte, ext interface{}) (err error) {
+	if ext != nil && h.bigExtTag != 0 && tag == h.bigExtTag {
+		return fmt.Errorf("codec/msgpack: SetExt: Tag %v is the math/big extension tag", tag)
+	}
+	return h.extHandle.SetExt(rt, tag, ext)
+}
+
@@ -29,6 +29,8 @@ type rpcCodec struct {
 	dec *Decoder
 	enc *Encoder
 	encbuf *bufio.Writer 
+	// sep writes a newline after each value (see Handle.valueSeparator).
+	sep bool
 }
 
 type goRpcCodec struct {
@@ -50,6 +52,7 @@ func (x goRpc) ClientCodec(conn io.ReadWriteCloser, h Handle) rpc.ClientCodec {
 func newRPCCodec(conn io.ReadWriteCloser, h Handle) rpcCodec {
 	encbuf := bufio.NewWriter(conn)
 	return rpcCodec{
+		sep: h.valueSeparator(),
 		rwc: conn,
 		encbuf: encbuf,
 		enc: NewEncoder(encbuf, h),
@@ -61,11 +64,11 @@ func newRPCCodec(conn io.ReadWriteCloser, h Handle) rpcCodec {
 
 // /////////////// RPC Codec Shared Methods ///////////////////
 func (c *rpcCodec) write(obj1, obj2 interface{}, writeObj2, doFlush bool) (err error) {
-	if err = c.enc.Encode(obj1); err != nil {
+	if err = c.encode(obj1); err != nil {
 		return
 	}
 	if writeObj2 {
-		if err = c.enc.Encode(obj2); err != nil {
+		if err = c.encode(obj2); err != nil {
 			return
 		}
 	}
@@ -76,12 +79,20 @@ func (c *rpcCodec) write(obj1, obj2 interface{}, writeObj2, doFlush bool) (err e
 	return
 }
 
+func (c *rpcCodec) encode(obj interface{}) (err error) {
+	if err = c.enc.Encode(obj); err != nil {
+		return
+	}
+	if c.sep && c.encbuf != nil {
+		err = c.encbuf.WriteByte('\n')
+	}
+	return
+}
 
 func (c *rpcCodec) read(obj interface{}) (err error) {
 	//If nil is passed in, we should still attempt to read content to nowhere.
 	if obj == nil {
-		var obj2 interface{}
-		return c.dec.Decode(&obj2)
+		return c.dec.Skip()
 	}
 	return c.dec.Decode(obj)
 }